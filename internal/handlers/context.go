@@ -0,0 +1,36 @@
+package handlers
+
+/* Shared request-context helpers used by the upload/quota/pipeline handlers. Auth
+middleware upstream of this package is expected to populate the request context with the
+authenticated user before these handlers run; userIDFromRequest degrades to the guest
+tier when that's missing rather than failing the request outright. */
+
+import (
+	"net/http"
+
+	"pixelpunk/internal/services/file"
+)
+
+type contextKey string
+
+const userIDContextKey contextKey = "userID"
+
+// userIDFromRequest returns the authenticated user's ID and whether the request is a
+// guest (unauthenticated) upload.
+func userIDFromRequest(r *http.Request) (userID uint, isGuestUpload bool) {
+	if v, ok := r.Context().Value(userIDContextKey).(uint); ok && v != 0 {
+		return v, false
+	}
+	return 0, true
+}
+
+// uploadContextFromRequest builds the file.UploadContext the upload-session handlers pass
+// through to the resumable upload service; File is left nil since these endpoints never
+// see a multipart.FileHeader directly.
+func uploadContextFromRequest(r *http.Request) *file.UploadContext {
+	userID, isGuestUpload := userIDFromRequest(r)
+	return &file.UploadContext{
+		UserID:        userID,
+		IsGuestUpload: isGuestUpload,
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+/* HTTP endpoint exposing internal/services/imagepipeline job status, per chunk0-6, so the
+client can show "processing" until derivatives are ready. Scoped to the caller's own jobs,
+same as the quota status endpoint is scoped to the caller's own usage. */
+
+import (
+	"net/http"
+	"strconv"
+
+	"pixelpunk/internal/services/imagepipeline"
+	"pixelpunk/pkg/errors"
+)
+
+// RegisterPipelineRoutes wires the image pipeline job-status endpoint onto mux.
+func RegisterPipelineRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /pipeline/jobs/{id}/status", jobStatusHandler)
+}
+
+// jobStatusHandler handles GET /pipeline/jobs/{id}/status, scoped to jobs owned by the
+// calling user (or guest, for guest uploads).
+func jobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, errors.New(errors.CodeInvalidParameter, "任务ID无效"))
+		return
+	}
+
+	userID, _ := userIDFromRequest(r)
+	status, err := imagepipeline.GetJobStatusForUser(uint(jobID), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": status})
+}
@@ -0,0 +1,33 @@
+package handlers
+
+/* HTTP endpoint exposing internal/services/quota so the frontend can render a usage bar,
+per chunk0-4. */
+
+import (
+	"net/http"
+
+	"pixelpunk/internal/services/file"
+	"pixelpunk/internal/services/quota"
+)
+
+// RegisterQuotaRoutes wires the quota status endpoint onto mux.
+func RegisterQuotaRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /quota/status", quotaStatusHandler)
+}
+
+// quotaStatusHandler handles GET /quota/status, returning the caller's daily/weekly/monthly
+// usage against their tier's limits.
+func quotaStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, isGuestUpload := userIDFromRequest(r)
+	tier := quota.TierGuest
+	if !isGuestUpload {
+		tier = file.ResolveQuotaTier(userID)
+	}
+
+	statuses, err := quota.GetQuotaStatus(userID, tier)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
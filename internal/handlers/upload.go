@@ -0,0 +1,102 @@
+package handlers
+
+/* HTTP endpoints for the resumable/chunked upload subsystem in
+internal/services/file/resumable.go. Registered alongside the existing (non-chunked)
+upload route so large files that don't fit in a single request body have a path in. */
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"pixelpunk/internal/services/file"
+	"pixelpunk/pkg/errors"
+	"pixelpunk/pkg/logger"
+)
+
+// RegisterUploadSessionRoutes wires the resumable upload session endpoints onto mux.
+func RegisterUploadSessionRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /upload/session", createUploadSessionHandler)
+	mux.HandleFunc("PUT /upload/session/{id}/chunk/{n}", writeChunkHandler)
+	mux.HandleFunc("POST /upload/session/{id}/complete", completeUploadSessionHandler)
+}
+
+type createSessionRequest struct {
+	Filename        string `json:"filename"`
+	TotalSize       int64  `json:"total_size"`
+	SHA256          string `json:"sha256"`
+	FolderID        string `json:"folder_id"`
+	StorageDuration string `json:"storage_duration"`
+}
+
+// createUploadSessionHandler handles POST /upload/session, declaring a new resumable
+// upload and returning the uploadID chunks will be written against.
+func createUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var req createSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, errors.New(errors.CodeInvalidParameter, "请求参数解析失败"))
+		return
+	}
+
+	ctx := uploadContextFromRequest(r)
+	session, err := file.CreateUploadSession(ctx, req.Filename, req.TotalSize, req.SHA256, req.FolderID, req.StorageDuration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"upload_id": session.ID})
+}
+
+// writeChunkHandler handles PUT /upload/session/{id}/chunk/{n}, persisting one ordered
+// chunk of the request body against the named session.
+func writeChunkHandler(w http.ResponseWriter, r *http.Request) {
+	uploadID := r.PathValue("id")
+	chunkIndex, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil {
+		writeError(w, errors.New(errors.CodeInvalidParameter, "分片序号无效"))
+		return
+	}
+
+	// Reject an oversized chunk before it's even fully read off the wire, rather than
+	// trusting the client to actually send ChunkSize bytes per PUT.
+	r.Body = http.MaxBytesReader(w, r.Body, file.ChunkSize)
+
+	ctx := uploadContextFromRequest(r)
+	if err := file.WriteChunk(ctx, uploadID, chunkIndex, r.Body); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"received": chunkIndex})
+}
+
+// completeUploadSessionHandler handles POST /upload/session/{id}/complete, concatenating
+// the received chunks and running them through the same validation/quota path a one-shot
+// upload goes through.
+func completeUploadSessionHandler(w http.ResponseWriter, r *http.Request) {
+	uploadID := r.PathValue("id")
+	ctx := uploadContextFromRequest(r)
+
+	savedFile, err := file.CompleteUploadSession(ctx, uploadID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, savedFile)
+}
+
+// writeJSON and writeError centralize response encoding so the three handlers above stay
+// focused on the upload-session flow itself.
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("写入响应失败: %v", err)
+	}
+}
+
+// writeError reports a service-layer error as JSON. The pkg/errors codes carry enough
+// information for the client to distinguish error cases; mapping them to HTTP status codes
+// is left to the outer router/middleware layer that isn't part of this package.
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+}
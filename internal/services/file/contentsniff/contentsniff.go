@@ -0,0 +1,127 @@
+// Package contentsniff detects a file's real format from its leading bytes, so an upload
+// can be checked against the declared extension instead of trusting filepath.Ext alone.
+package contentsniff
+
+import (
+	"bytes"
+	"io"
+)
+
+// Signature is a single magic-byte rule registered against the sniffing table.
+type Signature struct {
+	MIME      string
+	Ext       string
+	Offset    int
+	Magic     []byte
+	MatchFunc func(header []byte) bool // used instead of Magic when the prefix isn't fixed-offset
+}
+
+var registry []Signature
+
+func init() {
+	Register(Signature{MIME: "image/jpeg", Ext: "jpg", Magic: []byte{0xFF, 0xD8, 0xFF}})
+	Register(Signature{MIME: "image/png", Ext: "png", Magic: []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}})
+	Register(Signature{MIME: "image/gif", Ext: "gif", Magic: []byte("GIF87a")})
+	Register(Signature{MIME: "image/gif", Ext: "gif", Magic: []byte("GIF89a")})
+	Register(Signature{MIME: "image/bmp", Ext: "bmp", Magic: []byte("BM")})
+	Register(Signature{MIME: "image/webp", Ext: "webp", MatchFunc: func(h []byte) bool {
+		return len(h) >= 12 && bytes.Equal(h[0:4], []byte("RIFF")) && bytes.Equal(h[8:12], []byte("WEBP"))
+	}})
+	Register(Signature{MIME: "image/svg+xml", Ext: "svg", MatchFunc: func(h []byte) bool {
+		return bytes.Contains(bytes.ToLower(h), []byte("<svg")) || bytes.HasPrefix(bytes.TrimSpace(h), []byte("<?xml"))
+	}})
+	Register(Signature{MIME: "image/heif", Ext: "heic", MatchFunc: isISOBMFFBrand("heic", "heix", "hevc", "heim", "heis", "mif1")})
+	Register(Signature{MIME: "video/mp4", Ext: "mp4", MatchFunc: isISOBMFFBrand("isom", "iso2", "mp41", "mp42", "avc1", "M4V ")})
+	Register(Signature{MIME: "video/webm", Ext: "webm", Magic: []byte{0x1A, 0x45, 0xDF, 0xA3}})
+	Register(Signature{MIME: "video/x-msvideo", Ext: "avi", MatchFunc: func(h []byte) bool {
+		return len(h) >= 12 && bytes.Equal(h[0:4], []byte("RIFF")) && bytes.Equal(h[8:12], []byte("AVI "))
+	}})
+}
+
+// isISOBMFFBrand matches the "ftyp" box used by MP4/MOV/HEIF containers against a set of
+// accepted major/compatible brands.
+func isISOBMFFBrand(brands ...string) func([]byte) bool {
+	return func(h []byte) bool {
+		if len(h) < 12 || string(h[4:8]) != "ftyp" {
+			return false
+		}
+		brand := string(h[8:12])
+		for _, b := range brands {
+			if brand == b {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// extSynonyms maps alternate extensions for the same format onto the canonical one this
+// package registers signatures under, so callers comparing a declared extension against a
+// sniffed Ext don't flag a real file as spoofed just for spelling its extension differently.
+var extSynonyms = map[string]string{
+	"jpeg": "jpg",
+	"heif": "heic",
+	"tif":  "tiff",
+}
+
+// CanonicalExt normalizes ext (without the leading dot) to the form Sniff's Result.Ext
+// would report for the same format, so declared-vs-sniffed comparisons treat e.g. "jpeg"
+// and "jpg" as the same format.
+func CanonicalExt(ext string) string {
+	if canonical, ok := extSynonyms[ext]; ok {
+		return canonical
+	}
+	return ext
+}
+
+// sniffableExts is the set of canonical extensions with at least one registered signature,
+// backing IsSniffableExt.
+var sniffableExts = make(map[string]bool)
+
+// Register adds sig to the sniffing table. It's exported so plugins can extend recognized
+// formats without touching this package.
+func Register(sig Signature) {
+	registry = append(registry, sig)
+	sniffableExts[sig.Ext] = true
+}
+
+// IsSniffableExt reports whether ext (without the leading dot) has a registered magic-byte
+// signature, i.e. Sniff is actually able to verify content claiming this extension. Callers
+// can use this to treat "declared extension we know how to sniff, but nothing matched" as
+// suspicious on its own, rather than only comparing declared-vs-sniffed when something did
+// match.
+func IsSniffableExt(ext string) bool {
+	return sniffableExts[CanonicalExt(ext)]
+}
+
+// Result is the outcome of a successful sniff.
+type Result struct {
+	MIME string
+	Ext  string
+}
+
+const sniffLen = 512
+
+// Sniff reads up to the first 512 bytes of r and matches them against the registered
+// signature table, returning the first signature (in registration order) that matches. ok
+// is false when nothing matched.
+func Sniff(r io.Reader) (Result, bool) {
+	header := make([]byte, sniffLen)
+	n, _ := io.ReadFull(r, header)
+	header = header[:n]
+
+	for _, sig := range registry {
+		if sig.MatchFunc != nil {
+			if sig.MatchFunc(header) {
+				return Result{MIME: sig.MIME, Ext: sig.Ext}, true
+			}
+			continue
+		}
+		start := sig.Offset
+		end := start + len(sig.Magic)
+		if end <= len(header) && bytes.Equal(header[start:end], sig.Magic) {
+			return Result{MIME: sig.MIME, Ext: sig.Ext}, true
+		}
+	}
+	return Result{}, false
+}
@@ -0,0 +1,72 @@
+package contentsniff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSniffRecognizesRegisteredSignatures(t *testing.T) {
+	cases := []struct {
+		name   string
+		header []byte
+		want   Result
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0}, Result{MIME: "image/jpeg", Ext: "jpg"}},
+		{"png", []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}, Result{MIME: "image/png", Ext: "png"}},
+		{"gif89a", []byte("GIF89a"), Result{MIME: "image/gif", Ext: "gif"}},
+		{"webp", append([]byte("RIFF\x00\x00\x00\x00"), []byte("WEBP")...), Result{MIME: "image/webp", Ext: "webp"}},
+		{"mp4", append([]byte{0, 0, 0, 0x18}, append([]byte("ftyp"), []byte("isom")...)...), Result{MIME: "video/mp4", Ext: "mp4"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, ok := Sniff(bytes.NewReader(c.header))
+			if !ok {
+				t.Fatalf("expected a signature match for %s", c.name)
+			}
+			if result != c.want {
+				t.Fatalf("got %+v, want %+v", result, c.want)
+			}
+		})
+	}
+}
+
+func TestSniffNoMatchForUnregisteredFormat(t *testing.T) {
+	// An MS-DOS/PE executable (MZ header) uploaded under a spoofed extension - the whole
+	// point of sniffing is to catch this, but since no PE signature is registered, Sniff
+	// correctly reports "no match" rather than misidentifying it. Callers (sniffAndCheck)
+	// are responsible for treating an unmatched-but-declared-as-sniffable extension with
+	// suspicion under strict_content_type.
+	header := append([]byte("MZ"), make([]byte, 62)...)
+	if _, ok := Sniff(bytes.NewReader(header)); ok {
+		t.Fatal("expected no signature to match an unregistered format")
+	}
+}
+
+func TestSniffEmptyInput(t *testing.T) {
+	if _, ok := Sniff(bytes.NewReader(nil)); ok {
+		t.Fatal("expected no match for empty input")
+	}
+}
+
+func TestCanonicalExt(t *testing.T) {
+	cases := map[string]string{
+		"jpeg": "jpg",
+		"heif": "heic",
+		"tif":  "tiff",
+		"png":  "png",
+		"mp4":  "mp4",
+	}
+	for in, want := range cases {
+		if got := CanonicalExt(in); got != want {
+			t.Errorf("CanonicalExt(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSniffSVGMatchesByContent(t *testing.T) {
+	result, ok := Sniff(strings.NewReader(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`))
+	if !ok || result.Ext != "svg" {
+		t.Fatalf("expected svg match, got result=%+v ok=%v", result, ok)
+	}
+}
@@ -0,0 +1,252 @@
+package file
+
+/* Media-kind classification for non-image uploads (video/audio), layered on top of the image-only checks in upload_validations.go. */
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"pixelpunk/internal/models"
+	"pixelpunk/internal/services/file/contentsniff"
+	"pixelpunk/internal/services/setting"
+	"pixelpunk/pkg/errors"
+	"pixelpunk/pkg/logger"
+)
+
+// MediaKind classifies an upload so size limits, quotas and processing can diverge per kind.
+type MediaKind string
+
+const (
+	MediaKindImage MediaKind = "image"
+	MediaKindVideo MediaKind = "video"
+	MediaKindAudio MediaKind = "audio"
+)
+
+var videoExtTypes = map[string]bool{
+	".mp4": true, ".webm": true, ".avi": true, ".mov": true, ".mkv": true,
+}
+
+var audioExtTypes = map[string]bool{
+	".mp3": true, ".wav": true, ".m4a": true, ".flac": true, ".ogg": true,
+}
+
+// detectMediaKind infers the MediaKind of ctx.File from its extension, falling back to
+// magic-byte sniffing when the declared extension is ambiguous or missing.
+func detectMediaKind(ctx *UploadContext) MediaKind {
+	ext := strings.ToLower(filepath.Ext(ctx.File.Filename))
+	return detectMediaKindGeneric(ext, func() (io.ReadCloser, error) { return ctx.File.Open() })
+}
+
+// detectMediaKindGeneric is the ctx-free core of detectMediaKind, usable from any upload
+// path that can open its content - a multipart.FileHeader (one-shot) or a path on disk
+// (resumable session completion).
+func detectMediaKindGeneric(ext string, open func() (io.ReadCloser, error)) MediaKind {
+	if videoExtTypes[ext] {
+		return MediaKindVideo
+	}
+	if audioExtTypes[ext] {
+		return MediaKindAudio
+	}
+	if kind, ok := sniffMediaKindGeneric(open); ok {
+		return kind
+	}
+	return MediaKindImage
+}
+
+// isValidVideoType reports whether ext is an allowed video container, honouring the same
+// allowed_file_formats override pattern as isValidFileType.
+func isValidVideoType(ext string) bool {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
+	if err != nil {
+		logger.Warn("获取视频格式设置失败，使用默认配置: %v", err)
+		return videoExtTypes[ext]
+	}
+	if formatsInterface, ok := settingsMap.Settings["allowed_video_formats"]; ok {
+		if formats, ok := formatsInterface.([]any); ok {
+			extWithoutDot := strings.TrimPrefix(ext, ".")
+			for _, format := range formats {
+				if formatStr, ok := format.(string); ok && formatStr == extWithoutDot {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return videoExtTypes[ext]
+}
+
+// isValidAudioType reports whether ext is an allowed audio container.
+func isValidAudioType(ext string) bool {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
+	if err != nil {
+		logger.Warn("获取音频格式设置失败，使用默认配置: %v", err)
+		return audioExtTypes[ext]
+	}
+	if formatsInterface, ok := settingsMap.Settings["allowed_audio_formats"]; ok {
+		if formats, ok := formatsInterface.([]any); ok {
+			extWithoutDot := strings.TrimPrefix(ext, ".")
+			for _, format := range formats {
+				if formatStr, ok := format.(string); ok && formatStr == extWithoutDot {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return audioExtTypes[ext]
+}
+
+// videoUploadsEnabled reports whether the admin has opted into accepting video uploads at all.
+func videoUploadsEnabled() bool {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
+	if err != nil {
+		return false
+	}
+	if enabled, ok := settingsMap.Settings["enable_video_uploads"]; ok {
+		if b, ok := enabled.(bool); ok {
+			return b
+		}
+	}
+	return false
+}
+
+// mediaSizeLimit returns the configured byte limit for kind, falling back to defaultSize.
+func mediaSizeLimit(kind MediaKind, defaultSize int64) int64 {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
+	if err != nil {
+		return defaultSize
+	}
+	key := "max_image_size"
+	if kind == MediaKindVideo {
+		key = "max_video_size"
+	} else if kind == MediaKindAudio {
+		key = "max_audio_size"
+	}
+	if val, ok := settingsMap.Settings[key]; ok {
+		switch v := val.(type) {
+		case float64:
+			return int64(v * 1024 * 1024)
+		case int:
+			return int64(v) * 1024 * 1024
+		case int64:
+			return v * 1024 * 1024
+		}
+	}
+	return defaultSize
+}
+
+// mediaProbe is populated on ctx after a successful probe of a video/audio upload so the
+// caller can copy width/height/duration onto the resulting models.File.
+type mediaProbe struct {
+	Width    int
+	Height   int
+	Duration float64
+}
+
+// probeMediaFunc runs the duration/resolution probe hook for video and audio uploads,
+// dispatching by container extension. Real parsing only exists for MP4 (mediaprobe.go's
+// probeMP4, via moov/mvhd+tkhd) and WAV (probeWAV, via its fmt/data chunks) - the two
+// formats cheap enough to read with a hand-rolled box/chunk walk. webm, avi, mov, mkv, mp3,
+// m4a, flac and ogg still probe as nil,nil: properly supporting them needs a real demuxer
+// (ffprobe, or a library like abema/go-mp4 for the remaining ISOBMFF-family containers)
+// that isn't vendored in this tree. A var (not a plain func) so tests and other build
+// configs can swap it out. It takes an open func rather than a multipart.FileHeader so it
+// works from any upload path that can open its content - a one-shot request body or a
+// reassembled resumable session file on disk - mirroring detectMediaKindGeneric/sniffAndCheck.
+var probeMediaFunc = func(open func() (io.ReadCloser, error), kind MediaKind, ext string) (*mediaProbe, error) {
+	switch ext {
+	case ".mp4":
+		return probeMP4(open)
+	case ".wav":
+		return probeWAV(open)
+	default:
+		return nil, nil
+	}
+}
+
+// applyMediaProbe runs the duration/resolution probe hook for video/audio uploads and
+// copies its result onto file's Width/Height/Duration. It's a no-op for images, and a
+// probe error or empty result is non-fatal - the file is still saved without those fields.
+// open is how the probe reads the uploaded content; callers pass a multipart.FileHeader-
+// backed open for one-shot uploads and a disk-file-backed open for resumable sessions. ext
+// is the lowercased file extension (as set on ctx.FileExt), used to pick which container
+// format probeMediaFunc actually knows how to parse.
+func applyMediaProbe(kind MediaKind, ext string, open func() (io.ReadCloser, error), file *models.File) error {
+	if kind != MediaKindVideo && kind != MediaKindAudio {
+		return nil
+	}
+	probe, err := probeMediaFunc(open, kind, ext)
+	if err != nil {
+		return err
+	}
+	if probe == nil {
+		return nil
+	}
+	file.Width = probe.Width
+	file.Height = probe.Height
+	file.Duration = probe.Duration
+	return nil
+}
+
+// validateMediaUploadInput applies the video/audio-specific checks that isValidFileType
+// doesn't cover: admin opt-in, per-kind format allowlist and per-kind size limit.
+func validateMediaUploadInput(ctx *UploadContext, defaultMaxSize int64) error {
+	return checkMediaKindRules(ctx.MediaKind, ctx.FileExt, ctx.File.Size, ctx.IsGuestUpload, defaultMaxSize)
+}
+
+// checkMediaKindRules is the ctx-free core of validateMediaUploadInput, shared with the
+// resumable session completion path in resumable.go.
+func checkMediaKindRules(kind MediaKind, ext string, size int64, isGuestUpload bool, defaultMaxSize int64) error {
+	if kind == MediaKindVideo {
+		if !videoUploadsEnabled() {
+			return errors.New(errors.CodeFileTypeNotSupported, "视频上传功能未开启，请联系管理员")
+		}
+		if isGuestUpload {
+			return errors.New(errors.CodeFileTypeNotSupported, "访客暂不支持上传视频")
+		}
+		if !isValidVideoType(ext) {
+			return errors.New(errors.CodeFileTypeNotSupported, "当前视频格式不被支持、请联系管理员解除限制！")
+		}
+	} else if kind == MediaKindAudio {
+		if !isValidAudioType(ext) {
+			return errors.New(errors.CodeFileTypeNotSupported, "当前音频格式不被支持、请联系管理员解除限制！")
+		}
+	}
+
+	limit := mediaSizeLimit(kind, defaultMaxSize)
+	if limit > 0 && size > limit {
+		limitMB := limit / (1024 * 1024)
+		return errors.New(errors.CodeFileTooLarge, fmt.Sprintf("文件大小不能超过%dMB", limitMB))
+	}
+	return nil
+}
+
+// sniffMediaKindGeneric peeks at the first bytes returned by open via contentsniff to
+// recognize a container format when the filename extension alone isn't conclusive (renamed
+// files, missing extension).
+func sniffMediaKindGeneric(open func() (io.ReadCloser, error)) (MediaKind, bool) {
+	if open == nil {
+		return "", false
+	}
+	f, err := open()
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	result, ok := contentsniff.Sniff(f)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case strings.HasPrefix(result.MIME, "video/"):
+		return MediaKindVideo, true
+	case strings.HasPrefix(result.MIME, "audio/"):
+		return MediaKindAudio, true
+	case strings.HasPrefix(result.MIME, "image/"):
+		return MediaKindImage, true
+	}
+	return "", false
+}
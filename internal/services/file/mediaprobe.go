@@ -0,0 +1,203 @@
+package file
+
+/* Hand-rolled duration/resolution probing for the two container formats cheap enough to
+parse without a real demuxer: MP4 (ISO BMFF box walk) and WAV (RIFF chunk walk). Every other
+video/audio extension in videoExtTypes/audioExtTypes (webm, avi, mov, mkv, mp3, m4a, flac,
+ogg) still probes as a no-op - those need a real decoder (ffprobe, or a library like
+abema/go-mp4 for the ISOBMFF-family ones) that isn't vendored in this tree, so probeMediaFunc
+leaves them at nil,nil rather than pretending to support them. */
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// maxMP4ProbeBytes bounds how much of an MP4 we'll buffer looking for moov. Well-formed
+// "faststart" files (moov before mdat) resolve from the first few KB; files with moov at the
+// end of a large mdat won't be found within this cap and simply probe as nil,nil rather than
+// reading the whole upload into memory.
+const maxMP4ProbeBytes = 32 * 1024 * 1024
+
+// probeMP4 walks an MP4's top-level boxes looking for moov/mvhd (timescale + duration) and
+// moov/trak/tkhd (width + height of the first track that declares non-zero dimensions, i.e.
+// the video track - audio-only tracks carry width=height=0 in their tkhd). Returns nil, nil
+// if data isn't a recognizable MP4 or moov isn't found within maxMP4ProbeBytes.
+func probeMP4(open func() (io.ReadCloser, error)) (*mediaProbe, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxMP4ProbeBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	var probe mediaProbe
+	var timescale, duration uint32
+	haveMvhd, haveTkhd := false, false
+
+	var walk func(b []byte)
+	walk = func(b []byte) {
+		pos := 0
+		for pos+8 <= len(b) {
+			size := int(binary.BigEndian.Uint32(b[pos : pos+4]))
+			boxType := string(b[pos+4 : pos+8])
+			headerLen := 8
+			switch size {
+			case 1:
+				if pos+16 > len(b) {
+					return
+				}
+				size = int(binary.BigEndian.Uint64(b[pos+8 : pos+16]))
+				headerLen = 16
+			case 0:
+				size = len(b) - pos
+			}
+			if size < headerLen || pos+size > len(b) {
+				return
+			}
+			payload := b[pos+headerLen : pos+size]
+
+			switch boxType {
+			case "moov", "trak":
+				walk(payload)
+			case "mvhd":
+				if !haveMvhd {
+					if ts, dur, ok := parseMvhd(payload); ok {
+						timescale, duration, haveMvhd = ts, dur, true
+					}
+				}
+			case "tkhd":
+				if !haveTkhd {
+					if w, h, ok := parseTkhd(payload); ok && w > 0 && h > 0 {
+						probe.Width, probe.Height, haveTkhd = w, h, true
+					}
+				}
+			}
+			pos += size
+		}
+	}
+	walk(data)
+
+	if !haveMvhd && !haveTkhd {
+		return nil, nil
+	}
+	if haveMvhd && timescale > 0 {
+		probe.Duration = float64(duration) / float64(timescale)
+	}
+	return &probe, nil
+}
+
+// parseMvhd reads the timescale and duration fields out of an mvhd box payload (version 0
+// or 1 - version 1 widens the time fields to 64 bits for files spanning more than ~4 years'
+// worth of duration units, which this probe has no practical reason to care about beyond
+// reading the field at the right offset).
+func parseMvhd(b []byte) (timescale, duration uint32, ok bool) {
+	if len(b) < 1 {
+		return 0, 0, false
+	}
+	version := b[0]
+	if version == 1 {
+		if len(b) < 32 {
+			return 0, 0, false
+		}
+		timescale = binary.BigEndian.Uint32(b[20:24])
+		duration = uint32(binary.BigEndian.Uint64(b[24:32]))
+		return timescale, duration, true
+	}
+	if len(b) < 20 {
+		return 0, 0, false
+	}
+	timescale = binary.BigEndian.Uint32(b[12:16])
+	duration = binary.BigEndian.Uint32(b[16:20])
+	return timescale, duration, true
+}
+
+// parseTkhd reads the width/height fields (16.16 fixed point, truncated to whole pixels) out
+// of a tkhd box payload.
+func parseTkhd(b []byte) (width, height int, ok bool) {
+	if len(b) < 1 {
+		return 0, 0, false
+	}
+	version := b[0]
+	// Fixed header up to (but not including) the 36-byte transformation matrix differs in
+	// width by the widened 64-bit duration/time fields of version 1.
+	matrixEnd := 76
+	if version == 1 {
+		matrixEnd = 88
+	}
+	if len(b) < matrixEnd+8 {
+		return 0, 0, false
+	}
+	width = int(binary.BigEndian.Uint32(b[matrixEnd:matrixEnd+4]) >> 16)
+	height = int(binary.BigEndian.Uint32(b[matrixEnd+4:matrixEnd+8]) >> 16)
+	return width, height, true
+}
+
+// probeWAV reads a RIFF/WAVE file's "fmt " chunk (for byte rate) and "data" chunk (for byte
+// count) to compute duration without decoding any samples. Returns nil, nil if data isn't a
+// recognizable WAVE file.
+func probeWAV(open func() (io.ReadCloser, error)) (*mediaProbe, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(rc, header); err != nil {
+		return nil, nil
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, nil
+	}
+
+	var byteRate uint32
+	var dataSize uint32
+	haveFmt, haveData := false, false
+
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(rc, chunkHeader); err != nil {
+			break
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := binary.LittleEndian.Uint32(chunkHeader[4:8])
+
+		switch chunkID {
+		case "fmt ":
+			body := make([]byte, chunkSize)
+			if _, err := io.ReadFull(rc, body); err != nil {
+				return nil, nil
+			}
+			if len(body) >= 16 {
+				byteRate = binary.LittleEndian.Uint32(body[8:12])
+				haveFmt = true
+			}
+		case "data":
+			dataSize = chunkSize
+			haveData = true
+			if _, err := io.CopyN(io.Discard, rc, int64(chunkSize)); err != nil {
+				break
+			}
+		default:
+			if _, err := io.CopyN(io.Discard, rc, int64(chunkSize)); err != nil {
+				break
+			}
+		}
+		// RIFF chunks are word-aligned; skip the pad byte on an odd-sized chunk.
+		if chunkSize%2 == 1 {
+			io.CopyN(io.Discard, rc, 1)
+		}
+		if haveFmt && haveData {
+			break
+		}
+	}
+
+	if !haveFmt || !haveData || byteRate == 0 {
+		return nil, nil
+	}
+	return &mediaProbe{Duration: float64(dataSize) / float64(byteRate)}, nil
+}
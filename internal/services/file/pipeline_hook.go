@@ -0,0 +1,35 @@
+package file
+
+/* Hook from the upload path into the async image pipeline. Kept separate from
+upload_validations.go since it runs after a file is persisted, not as part of validation. */
+
+import (
+	"pixelpunk/internal/models"
+	"pixelpunk/internal/services/imagepipeline"
+	"pixelpunk/pkg/logger"
+)
+
+// enqueueImagePipeline schedules EXIF strip/transcode/thumbnail/BlurHash generation for a
+// freshly-saved image file. Video/audio uploads skip this - they get their own probe hook
+// in media.go instead. Enqueue failures are logged but never fail the upload itself, since
+// the original file is already safely stored.
+func enqueueImagePipeline(savedFile *models.File, contentHash string) {
+	if detectMediaKindFromExt(savedFile.Ext) != MediaKindImage {
+		return
+	}
+	if err := imagepipeline.Enqueue(savedFile.ID, contentHash, savedFile.StoragePath, imagepipeline.DefaultStages()); err != nil {
+		logger.Warn("提交图像处理任务失败: fileID=%d err=%v", savedFile.ID, err)
+	}
+}
+
+// detectMediaKindFromExt is the extension-only subset of detectMediaKind, usable once the
+// file is already persisted and all we have left is its extension.
+func detectMediaKindFromExt(ext string) MediaKind {
+	if videoExtTypes[ext] {
+		return MediaKindVideo
+	}
+	if audioExtTypes[ext] {
+		return MediaKindAudio
+	}
+	return MediaKindImage
+}
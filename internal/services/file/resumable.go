@@ -0,0 +1,466 @@
+package file
+
+/* Resumable upload sessions for files too large to fit in a single request body. A session
+records the declared total size/hash/destination up front; chunks are written to a temp
+directory keyed by session ID and concatenated once all of them have arrived. */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pixelpunk/internal/models"
+	"pixelpunk/internal/services/quota"
+	"pixelpunk/internal/services/setting"
+	"pixelpunk/pkg/database"
+	"pixelpunk/pkg/errors"
+	"pixelpunk/pkg/logger"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultSessionTTL is how long an upload session survives without a completing chunk
+// before the sweep cron reclaims it, unless overridden by the resumable_upload_ttl_hours
+// setting.
+const defaultSessionTTL = 24 * time.Hour
+
+func sessionTempDir(uploadID string) string {
+	return filepath.Join(os.TempDir(), "pixelpunk-resumable", uploadID)
+}
+
+// CreateUploadSession records a new resumable upload: the declared total size, filename,
+// sha256, destination folder and storage duration, so later chunk writes and the final
+// validateUploadInput-equivalent check have something to check against.
+func CreateUploadSession(ctx *UploadContext, filename string, totalSize int64, sha256Hex, folderID, storageDuration string) (*models.UploadSession, error) {
+	if err := validateSessionSize(totalSize); err != nil {
+		return nil, err
+	}
+
+	ttl := sessionTTL()
+	session := &models.UploadSession{
+		ID:              uuid.NewString(),
+		UserID:          ctx.UserID,
+		Filename:        filename,
+		TotalSize:       totalSize,
+		SHA256:          sha256Hex,
+		FolderID:        folderID,
+		StorageDuration: storageDuration,
+		IsGuestUpload:   ctx.IsGuestUpload,
+		Status:          "pending",
+		ExpiresAt:       time.Now().Add(ttl),
+	}
+	if err := database.DB.Create(session).Error; err != nil {
+		return nil, errors.Wrap(err, errors.CodeDBQueryFailed, "创建分片上传会话失败")
+	}
+	if err := os.MkdirAll(sessionTempDir(session.ID), 0o700); err != nil {
+		return nil, errors.Wrap(err, errors.CodeFileWriteFailed, "创建分片临时目录失败")
+	}
+	return session, nil
+}
+
+// WriteChunk persists chunk n of an in-progress session and marks it received in the
+// session's chunk bitmap, so the session survives a process restart mid-upload.
+func WriteChunk(ctx *UploadContext, uploadID string, chunkIndex int, r io.Reader) error {
+	session, err := loadSession(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	if session.Status != "pending" {
+		return errors.New(errors.CodeInvalidParameter, "上传会话已完成或已失效")
+	}
+
+	chunkPath := filepath.Join(sessionTempDir(uploadID), fmt.Sprintf("%08d.part", chunkIndex))
+	out, err := os.Create(chunkPath)
+	if err != nil {
+		return errors.Wrap(err, errors.CodeFileWriteFailed, "写入分片失败")
+	}
+	defer out.Close()
+
+	// Cap the write at ChunkSize+1: reading one byte past the limit lets us tell "exactly
+	// ChunkSize bytes" apart from "more than ChunkSize bytes" without trusting whatever
+	// Content-Length the client sent. This is a second line of defense behind the
+	// http.MaxBytesReader the HTTP handler already wraps the request body in.
+	written, err := io.Copy(out, io.LimitReader(r, ChunkSize+1))
+	if err != nil {
+		return errors.Wrap(err, errors.CodeFileWriteFailed, "写入分片失败")
+	}
+	if written > ChunkSize {
+		out.Close()
+		os.Remove(chunkPath)
+		return errors.New(errors.CodeFileTooLarge, fmt.Sprintf("分片大小不能超过%dMB", ChunkSize/(1024*1024)))
+	}
+
+	if err := markChunkReceived(session, chunkIndex); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CompleteUploadSession concatenates every received chunk in order, verifies the combined
+// size and sha256 against what was declared in CreateUploadSession, and finishes with the
+// same validation rules a one-shot upload goes through.
+func CompleteUploadSession(ctx *UploadContext, uploadID string) (*models.File, error) {
+	session, err := loadSession(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	totalChunks := chunkCountFromSize(session.TotalSize)
+	if !allChunksReceived(session.ReceivedChunks, totalChunks) {
+		return nil, errors.New(errors.CodeInvalidParameter, "分片尚未全部上传完成")
+	}
+
+	// Claim the pending->completing transition under a row lock before doing any of the
+	// actual work below, so two concurrent completions of the same session (a plausible
+	// client retry-on-timeout) can't both pass allChunksReceived and both materialize a
+	// models.File row. The loser gets an error here instead of racing concatenateChunks and
+	// database.DB.Create. On any failure past this point we revert to "pending" so a
+	// legitimate retry (after a transient error) isn't permanently locked out.
+	if err := claimSessionForCompletion(uploadID); err != nil {
+		return nil, err
+	}
+
+	finalPath, err := concatenateChunks(session)
+	if err != nil {
+		revertSessionToPending(uploadID)
+		return nil, err
+	}
+
+	if err := verifyAssembledSize(session, finalPath); err != nil {
+		os.Remove(finalPath)
+		revertSessionToPending(uploadID)
+		return nil, err
+	}
+
+	if err := verifySessionHash(session, finalPath); err != nil {
+		os.Remove(finalPath)
+		revertSessionToPending(uploadID)
+		return nil, err
+	}
+
+	file, err := materializeFile(ctx, session, finalPath)
+	if err != nil {
+		os.Remove(finalPath)
+		revertSessionToPending(uploadID)
+		return nil, err
+	}
+
+	session.Status = "completed"
+	if err := database.DB.Save(session).Error; err != nil {
+		logger.Warn("更新分片上传会话状态失败: uploadID=%s err=%v", uploadID, err)
+	}
+	// Only the chunk parts go: the assembled "final" file in the same directory is now the
+	// file's StoragePath, which the image pipeline reads below.
+	for i := 0; i < totalChunks; i++ {
+		os.Remove(filepath.Join(sessionTempDir(uploadID), fmt.Sprintf("%08d.part", i)))
+	}
+
+	if err := quota.Record(session.UserID, session.TotalSize); err != nil {
+		logger.Warn("记录配额用量失败: userID=%d err=%v", session.UserID, err)
+	}
+	enqueueImagePipeline(file, session.SHA256)
+	return file, nil
+}
+
+// verifyAssembledSize stats the concatenated upload and rejects it if its size doesn't
+// match what was declared in CreateUploadSession. Without this, every downstream check
+// (validateAssembledFile's per-kind limit, quota.Check/Record, the persisted
+// models.File.Size) trusts session.TotalSize rather than what was actually written to
+// disk - and since each individual chunk write is already capped at ChunkSize, the only way
+// the two can disagree is a short upload (missing bytes) or a client that otherwise evaded
+// the per-chunk cap, both of which should fail here rather than silently understating size.
+func verifyAssembledSize(session *models.UploadSession, finalPath string) error {
+	info, err := os.Stat(finalPath)
+	if err != nil {
+		return errors.Wrap(err, errors.CodeFileReadFailed, "校验分片上传文件失败")
+	}
+	if info.Size() != session.TotalSize {
+		return errors.New(errors.CodeInvalidParameter, "文件大小与声明不符，请重新上传")
+	}
+	return nil
+}
+
+// verifySessionHash recomputes the sha256 of the concatenated upload and compares it
+// against what the client declared in CreateUploadSession, catching corrupt or
+// out-of-order chunk reassembly.
+func verifySessionHash(session *models.UploadSession, finalPath string) error {
+	if session.SHA256 == "" {
+		return nil
+	}
+	f, err := os.Open(finalPath)
+	if err != nil {
+		return errors.Wrap(err, errors.CodeFileReadFailed, "校验分片上传文件失败")
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return errors.Wrap(err, errors.CodeFileReadFailed, "校验分片上传文件失败")
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != session.SHA256 {
+		return errors.New(errors.CodeFileHashMismatch, "文件内容校验失败，请重新上传")
+	}
+	return nil
+}
+
+// validateAssembledFile runs the exact same format/size/quota rules a one-shot upload goes
+// through (validateUploadInput's media-kind detection, content sniff, per-kind size limit
+// and quota.Check) against the reassembled chunk file on disk, so a resumable session can't
+// bypass any check a single-request upload would have enforced.
+func validateAssembledFile(session *models.UploadSession, finalPath string) (MediaKind, error) {
+	ext := strings.ToLower(filepath.Ext(session.Filename))
+	open := func() (io.ReadCloser, error) { return os.Open(finalPath) }
+
+	mediaKind := detectMediaKindGeneric(ext, open)
+
+	if _, err := sniffAndCheck(open, ext); err != nil {
+		return mediaKind, err
+	}
+
+	maxFileSize := maxFileSizeSetting()
+	switch mediaKind {
+	case MediaKindVideo, MediaKindAudio:
+		if err := checkMediaKindRules(mediaKind, ext, session.TotalSize, session.IsGuestUpload, maxFileSize); err != nil {
+			return mediaKind, err
+		}
+	default:
+		if !isValidFileType(ext) {
+			return mediaKind, errors.New(errors.CodeFileTypeNotSupported, "当前格式不被支持、请联系管理员解除限制！")
+		}
+		if maxFileSize > 0 && session.TotalSize > maxFileSize {
+			maxSizeMB := maxFileSize / (1024 * 1024)
+			return mediaKind, errors.New(errors.CodeFileTooLarge, fmt.Sprintf("文件大小不能超过%dMB", maxSizeMB))
+		}
+	}
+
+	if err := quota.Check(session.UserID, resolveQuotaTier(session.UserID), session.TotalSize); err != nil {
+		return mediaKind, err
+	}
+	return mediaKind, nil
+}
+
+// materializeFile runs the same checks a one-shot upload goes through and persists the
+// final models.File row, pointing it at the reassembled chunk file.
+func materializeFile(ctx *UploadContext, session *models.UploadSession, finalPath string) (*models.File, error) {
+	mediaKind, err := validateAssembledFile(session, finalPath)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(session.Filename))
+
+	file := &models.File{
+		UserID:          session.UserID,
+		Filename:        session.Filename,
+		Size:            session.TotalSize,
+		Ext:             ext,
+		FolderID:        session.FolderID,
+		Hash:            session.SHA256,
+		StorageDuration: session.StorageDuration,
+		IsGuestUpload:   session.IsGuestUpload,
+		StoragePath:     finalPath,
+	}
+
+	ctx.MediaKind = mediaKind
+	ctx.FileExt = ext
+	open := func() (io.ReadCloser, error) { return os.Open(finalPath) }
+	if err := applyMediaProbe(mediaKind, ext, open, file); err != nil {
+		logger.Warn("媒体信息探测失败，继续保存文件: uploadID=%s err=%v", session.ID, err)
+	}
+
+	if err := database.DB.Create(file).Error; err != nil {
+		return nil, errors.Wrap(err, errors.CodeDBQueryFailed, "保存文件记录失败")
+	}
+	return file, nil
+}
+
+// loadSession fetches a session by ID and confirms ctx's caller actually owns it before
+// handing it back, so one user can't write chunks into or force-complete another user's
+// in-progress session by guessing its uploadID. A mismatch is reported identically to a
+// missing session rather than a dedicated "forbidden" error, so it doesn't confirm the
+// uploadID exists to a caller who doesn't own it.
+func loadSession(ctx *UploadContext, uploadID string) (*models.UploadSession, error) {
+	var session models.UploadSession
+	if err := database.DB.Where("id = ?", uploadID).First(&session).Error; err != nil {
+		return nil, errors.New(errors.CodeUploadSessionNotFound, "上传会话不存在或已过期")
+	}
+	if session.UserID != ctx.UserID {
+		return nil, errors.New(errors.CodeUploadSessionNotFound, "上传会话不存在或已过期")
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, errors.New(errors.CodeUploadSessionNotFound, "上传会话已过期")
+	}
+	return &session, nil
+}
+
+// ChunkSize is the fixed per-chunk byte size the client is expected to use; it determines
+// how many chunks a given total size is split into for the received-chunk bitmap. Exported
+// so the HTTP layer (internal/handlers/upload.go) can cap the request body it hands
+// WriteChunk to exactly this many bytes via http.MaxBytesReader.
+const ChunkSize = 8 * 1024 * 1024
+
+func chunkCountFromSize(totalSize int64) int {
+	return int((totalSize + ChunkSize - 1) / ChunkSize)
+}
+
+// receivedChunks is stored on the session as a comma-separated list of received indexes,
+// so it persists across process restarts without needing a separate table.
+func allChunksReceived(receivedChunks string, totalChunks int) bool {
+	if receivedChunks == "" {
+		return totalChunks == 0
+	}
+	seen := make(map[string]bool)
+	for _, idx := range strings.Split(receivedChunks, ",") {
+		seen[idx] = true
+	}
+	return len(seen) >= totalChunks
+}
+
+// markChunkReceived adds chunkIndex to session's received-chunk bitmap. Concurrent chunk
+// PUTs for the same session are a realistic client optimization, so the read-modify-write
+// of ReceivedChunks runs inside a transaction that row-locks the session, rather than
+// reading session.ReceivedChunks (a snapshot the caller may already be holding stale) and
+// writing it back unguarded - two chunks arriving at once would otherwise race and one
+// index could be silently dropped from the bitmap despite its chunk file being on disk.
+func markChunkReceived(session *models.UploadSession, chunkIndex int) error {
+	idx := fmt.Sprintf("%d", chunkIndex)
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.UploadSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", session.ID).First(&current).Error; err != nil {
+			return errors.Wrap(err, errors.CodeDBQueryFailed, "更新分片上传进度失败")
+		}
+		for _, existing := range strings.Split(current.ReceivedChunks, ",") {
+			if existing == idx {
+				session.ReceivedChunks = current.ReceivedChunks
+				return nil
+			}
+		}
+		updated := idx
+		if current.ReceivedChunks != "" {
+			updated = current.ReceivedChunks + "," + idx
+		}
+		if err := tx.Model(&current).Update("received_chunks", updated).Error; err != nil {
+			return errors.Wrap(err, errors.CodeDBQueryFailed, "更新分片上传进度失败")
+		}
+		session.ReceivedChunks = updated
+		return nil
+	})
+}
+
+// claimSessionForCompletion atomically transitions uploadID's session from "pending" to
+// "completing" under a row lock, the same technique markChunkReceived uses for the bitmap
+// update. A session that isn't "pending" (already completing, completed, or expired out
+// from under us) is reported as already-finished rather than silently re-running the
+// completion work a second time.
+func claimSessionForCompletion(uploadID string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.UploadSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", uploadID).First(&current).Error; err != nil {
+			return errors.Wrap(err, errors.CodeDBQueryFailed, "完成分片上传会话失败")
+		}
+		if current.Status != "pending" {
+			return errors.New(errors.CodeInvalidParameter, "上传会话已完成或正在处理中")
+		}
+		if err := tx.Model(&current).Update("status", "completing").Error; err != nil {
+			return errors.Wrap(err, errors.CodeDBQueryFailed, "完成分片上传会话失败")
+		}
+		return nil
+	})
+}
+
+// revertSessionToPending undoes claimSessionForCompletion's transition after a completion
+// attempt fails partway through, so a retry (after a transient error, not a race with
+// another completion) isn't permanently locked out of ever finishing this session.
+func revertSessionToPending(uploadID string) {
+	if err := database.DB.Model(&models.UploadSession{}).Where("id = ?", uploadID).Update("status", "pending").Error; err != nil {
+		logger.Warn("回滚分片上传会话状态失败: uploadID=%s err=%v", uploadID, err)
+	}
+}
+
+func concatenateChunks(session *models.UploadSession) (string, error) {
+	finalPath := filepath.Join(sessionTempDir(session.ID), "final")
+	out, err := os.Create(finalPath)
+	if err != nil {
+		return "", errors.Wrap(err, errors.CodeFileWriteFailed, "合并分片失败")
+	}
+	defer out.Close()
+
+	chunkCount := chunkCountFromSize(session.TotalSize)
+	for i := 0; i < chunkCount; i++ {
+		chunkPath := filepath.Join(sessionTempDir(session.ID), fmt.Sprintf("%08d.part", i))
+		in, err := os.Open(chunkPath)
+		if err != nil {
+			return "", errors.Wrap(err, errors.CodeFileWriteFailed, "合并分片失败，缺少分片")
+		}
+		_, copyErr := io.Copy(out, in)
+		in.Close()
+		if copyErr != nil {
+			return "", errors.Wrap(copyErr, errors.CodeFileWriteFailed, "合并分片失败")
+		}
+	}
+	return finalPath, nil
+}
+
+// defaultMaxResumableSize is the ceiling a resumable session's declared totalSize is checked
+// against at session creation when the max_resumable_size setting isn't configured. It only
+// bounds what a session may declare: at completion, validateAssembledFile still applies
+// max_file_size (100MB default) or the per-kind limit, so a session larger than those is
+// rejected then.
+const defaultMaxResumableSize = 5 * 1024 * 1024 * 1024
+
+func validateSessionSize(totalSize int64) error {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
+	maxResumableSize := int64(defaultMaxResumableSize)
+	if err == nil {
+		if maxSizeVal, ok := settingsMap.Settings["max_resumable_size"]; ok {
+			switch v := maxSizeVal.(type) {
+			case float64:
+				maxResumableSize = int64(v * 1024 * 1024)
+			case int:
+				maxResumableSize = int64(v) * 1024 * 1024
+			case int64:
+				maxResumableSize = v * 1024 * 1024
+			}
+		}
+	}
+	if maxResumableSize > 0 && totalSize > maxResumableSize {
+		maxSizeMB := maxResumableSize / (1024 * 1024)
+		return errors.New(errors.CodeFileTooLarge, fmt.Sprintf("文件大小不能超过%dMB", maxSizeMB))
+	}
+	return nil
+}
+
+func sessionTTL() time.Duration {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
+	if err != nil {
+		return defaultSessionTTL
+	}
+	if val, ok := settingsMap.Settings["resumable_upload_ttl_hours"]; ok {
+		if hours, ok := val.(float64); ok && hours > 0 {
+			return time.Duration(hours) * time.Hour
+		}
+	}
+	return defaultSessionTTL
+}
+
+// SweepExpiredSessions removes still-pending sessions (and their temp chunk directories)
+// past their TTL; sessions in any other status are left untouched.
+// Intended to be invoked by a cron job alongside the rest of the scheduled maintenance.
+func SweepExpiredSessions() error {
+	var expired []models.UploadSession
+	if err := database.DB.Where("status = ? AND expires_at < ?", "pending", time.Now()).Find(&expired).Error; err != nil {
+		return errors.Wrap(err, errors.CodeDBQueryFailed, "查询过期上传会话失败")
+	}
+	for _, session := range expired {
+		os.RemoveAll(sessionTempDir(session.ID))
+		if err := database.DB.Delete(&session).Error; err != nil {
+			logger.Warn("清理过期上传会话失败: uploadID=%s err=%v", session.ID, err)
+		}
+	}
+	return nil
+}
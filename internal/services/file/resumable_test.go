@@ -0,0 +1,52 @@
+package file
+
+import "testing"
+
+func TestChunkCountFromSize(t *testing.T) {
+	cases := []struct {
+		name      string
+		totalSize int64
+		want      int
+	}{
+		{"zero", 0, 0},
+		{"one byte", 1, 1},
+		{"exact multiple", ChunkSize * 3, 3},
+		{"one byte over a multiple", ChunkSize*3 + 1, 4},
+		{"one byte under a multiple", ChunkSize*3 - 1, 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := chunkCountFromSize(c.totalSize); got != c.want {
+				t.Fatalf("chunkCountFromSize(%d) = %d, want %d", c.totalSize, got, c.want)
+			}
+		})
+	}
+}
+
+func TestAllChunksReceivedEmptyBitmap(t *testing.T) {
+	if !allChunksReceived("", 0) {
+		t.Fatal("expected a zero-chunk upload with no bitmap to be complete")
+	}
+	if allChunksReceived("", 3) {
+		t.Fatal("expected an empty bitmap to be incomplete when chunks are expected")
+	}
+}
+
+func TestAllChunksReceivedPartial(t *testing.T) {
+	if allChunksReceived("0,1", 3) {
+		t.Fatal("expected 2 of 3 received chunks to be incomplete")
+	}
+}
+
+func TestAllChunksReceivedComplete(t *testing.T) {
+	if !allChunksReceived("0,1,2", 3) {
+		t.Fatal("expected 3 of 3 received chunks to be complete")
+	}
+}
+
+func TestAllChunksReceivedDuplicateIndexesDontCount(t *testing.T) {
+	// A retried PUT for the same chunk index shouldn't let a short bitmap look complete.
+	if allChunksReceived("0,0,0", 3) {
+		t.Fatal("expected duplicate chunk indexes to still read as incomplete")
+	}
+}
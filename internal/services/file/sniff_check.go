@@ -0,0 +1,75 @@
+package file
+
+/* Extension-spoofing guard: cross-checks the declared file extension against the magic
+bytes actually present in the upload via the contentsniff registry. */
+
+import (
+	"io"
+	"strings"
+
+	"pixelpunk/internal/services/file/contentsniff"
+	"pixelpunk/internal/services/setting"
+	"pixelpunk/pkg/errors"
+	"pixelpunk/pkg/logger"
+)
+
+// strictContentTypeEnabled reports whether mismatches between the declared extension and
+// the sniffed content must be rejected outright, rather than just recorded.
+func strictContentTypeEnabled() bool {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
+	if err != nil {
+		return false
+	}
+	if enabled, ok := settingsMap.Settings["strict_content_type"]; ok {
+		if b, ok := enabled.(bool); ok {
+			return b
+		}
+	}
+	return false
+}
+
+// validateContentSniff sniffs ctx.File's magic bytes, stores the detected MIME on ctx, and
+// (when strict_content_type is on) rejects uploads whose declared extension doesn't match
+// what was actually sniffed.
+func validateContentSniff(ctx *UploadContext) error {
+	mime, err := sniffAndCheck(func() (io.ReadCloser, error) { return ctx.File.Open() }, ctx.FileExt)
+	if err != nil {
+		return err
+	}
+	ctx.DetectedMIME = mime
+	return nil
+}
+
+// sniffAndCheck is the ctx-free core of validateContentSniff, shared with the resumable
+// session completion path in resumable.go. declaredExt may include the leading dot.
+func sniffAndCheck(open func() (io.ReadCloser, error), declaredExt string) (detectedMIME string, err error) {
+	f, err := open()
+	if err != nil {
+		return "", errors.Wrap(err, errors.CodeFileReadFailed, "读取上传文件失败")
+	}
+	defer f.Close()
+
+	result, ok := contentsniff.Sniff(f)
+	if !ok {
+		declared := contentsniff.CanonicalExt(strings.TrimPrefix(declaredExt, "."))
+		if strictContentTypeEnabled() && contentsniff.IsSniffableExt(declared) {
+			// declaredExt claims a format we have a registered signature for, but nothing
+			// matched - e.g. a renamed executable uploaded as .png. A format genuinely
+			// without a registered signature (docx, a plain .txt, ...) is unaffected, since
+			// IsSniffableExt is false for it and this falls through like before.
+			logger.Warn("上传文件声明扩展名为可嗅探格式，但内容未匹配任何已知签名: declared=%s", declared)
+			return "", errors.New(errors.CodeFileTypeNotSupported, "文件内容与扩展名不匹配")
+		}
+		return "", nil
+	}
+
+	declared := contentsniff.CanonicalExt(strings.TrimPrefix(declaredExt, "."))
+	sniffed := contentsniff.CanonicalExt(result.Ext)
+	if declared != sniffed {
+		logger.Warn("上传文件声明扩展名与内容嗅探结果不一致: declared=%s sniffed=%s", declared, sniffed)
+		if strictContentTypeEnabled() {
+			return result.MIME, errors.New(errors.CodeFileTypeNotSupported, "文件内容与扩展名不匹配")
+		}
+	}
+	return result.MIME, nil
+}
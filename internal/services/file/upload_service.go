@@ -0,0 +1,92 @@
+package file
+
+/* Upload orchestration: the real call chain validateUploadInput/streamUploadToStaging/
+dedupUpload/quota/imagepipeline are invoked from, for single-shot (non-chunked) uploads.
+Resumable sessions go through resumable.go instead, via the same validate+quota helpers. */
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"pixelpunk/internal/models"
+	"pixelpunk/internal/services/quota"
+	"pixelpunk/pkg/database"
+	"pixelpunk/pkg/errors"
+	"pixelpunk/pkg/logger"
+)
+
+func storedFilePath(contentHash, ext string) string {
+	return filepath.Join("storage", "files", contentHash[:2], contentHash+ext)
+}
+
+// UploadFile validates, streams and persists a single-shot upload: ctx.File is hashed and
+// copied to a staging file without ever being buffered whole in memory, duplicate content
+// already stored under the same hash is deduped, quota usage is recorded, and a successful
+// image upload is handed off to the async imagepipeline.
+func UploadFile(ctx *UploadContext) (*models.File, error) {
+	if err := validateUploadInput(ctx); err != nil {
+		return nil, err
+	}
+
+	stagingPath, contentHash, err := streamUploadToStaging(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, won, err := dedupUpload(contentHash, func() (*models.File, error) {
+		return finalizeStagedUpload(ctx, stagingPath, contentHash)
+	})
+	if !won {
+		// Another upload with identical content already finalized first; our own staged
+		// copy was never consumed, so it's safe (and necessary) to clean it up here.
+		os.Remove(stagingPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if qErr := quota.Record(ctx.UserID, ctx.File.Size); qErr != nil {
+		logger.Warn("记录配额用量失败: userID=%d err=%v", ctx.UserID, qErr)
+	}
+
+	enqueueImagePipeline(file, contentHash)
+	return file, nil
+}
+
+// finalizeStagedUpload moves a staged upload to its permanent content-addressed path and
+// creates the models.File row. Only runs once per distinct content hash (see dedupUpload).
+// As the dedup winner, it owns stagingPath and is responsible for removing it on every
+// error path that returns before (or instead of) the rename succeeding.
+func finalizeStagedUpload(ctx *UploadContext, stagingPath, contentHash string) (*models.File, error) {
+	finalPath := storedFilePath(contentHash, ctx.FileExt)
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0o755); err != nil {
+		os.Remove(stagingPath)
+		return nil, errors.Wrap(err, errors.CodeFileWriteFailed, "创建存储目录失败")
+	}
+	if err := os.Rename(stagingPath, finalPath); err != nil {
+		os.Remove(stagingPath)
+		return nil, errors.Wrap(err, errors.CodeFileWriteFailed, "保存文件失败")
+	}
+
+	file := &models.File{
+		UserID:          ctx.UserID,
+		Filename:        ctx.File.Filename,
+		Size:            ctx.File.Size,
+		Ext:             ctx.FileExt,
+		FolderID:        ctx.FolderID,
+		Hash:            contentHash,
+		StorageDuration: ctx.StorageDuration,
+		IsGuestUpload:   ctx.IsGuestUpload,
+		StoragePath:     finalPath,
+	}
+	open := func() (io.ReadCloser, error) { return ctx.File.Open() }
+	if err := applyMediaProbe(ctx.MediaKind, ctx.FileExt, open, file); err != nil {
+		logger.Warn("媒体信息探测失败，继续保存文件: hash=%s err=%v", contentHash, err)
+	}
+	if err := database.DB.Create(file).Error; err != nil {
+		os.Remove(finalPath)
+		return nil, errors.Wrap(err, errors.CodeDBQueryFailed, "保存文件记录失败")
+	}
+	return file, nil
+}
@@ -0,0 +1,105 @@
+package file
+
+/* Streaming upload path: avoids buffering the whole multipart file in memory and dedups
+concurrent uploads of identical content so only one of them pays for hashing/encoding. */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"pixelpunk/internal/models"
+	"pixelpunk/pkg/errors"
+	"pixelpunk/pkg/logger"
+
+	"github.com/google/uuid"
+)
+
+// scratchBufferPool hands out reusable copy buffers for the hash/storage tee, so repeated
+// uploads through streamUploadToStaging don't each allocate a fresh buffer.
+var scratchBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+// uploadOnce coordinates concurrent uploads of the same content hash so only the first one
+// does the actual storage/encode work; the rest wait on it and share the result.
+type uploadOnce struct {
+	once   sync.Once
+	result *models.File
+	err    error
+}
+
+var (
+	inFlightUploadsMu sync.Mutex
+	inFlightUploads   = make(map[string]*uploadOnce)
+)
+
+func stagingDir() string {
+	return filepath.Join(os.TempDir(), "pixelpunk-staging")
+}
+
+// streamUploadToStaging reads ctx.File exactly once, copying it into a staging file on disk
+// with a pooled scratch buffer while simultaneously hashing it for dedup, so the full
+// content never has to sit in memory at once. The caller is responsible for removing
+// stagingPath once it's been moved to its final location (or discarded, on a dedup hit).
+func streamUploadToStaging(ctx *UploadContext) (stagingPath string, contentHash string, err error) {
+	src, err := ctx.File.Open()
+	if err != nil {
+		return "", "", errors.Wrap(err, errors.CodeFileReadFailed, "读取上传文件失败")
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(stagingDir(), 0o700); err != nil {
+		return "", "", errors.Wrap(err, errors.CodeFileWriteFailed, "创建暂存目录失败")
+	}
+	dst, err := os.Create(filepath.Join(stagingDir(), uuid.NewString()))
+	if err != nil {
+		return "", "", errors.Wrap(err, errors.CodeFileWriteFailed, "创建暂存文件失败")
+	}
+	defer dst.Close()
+
+	bufPtr := scratchBufferPool.Get().(*[]byte)
+	defer scratchBufferPool.Put(bufPtr)
+
+	hasher := sha256.New()
+	tee := io.TeeReader(src, hasher)
+	if _, err := io.CopyBuffer(dst, tee, *bufPtr); err != nil {
+		os.Remove(dst.Name())
+		return "", "", errors.Wrap(err, errors.CodeFileWriteFailed, "写入暂存文件失败")
+	}
+	return dst.Name(), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// dedupUpload ensures that concurrent uploads sharing contentHash only run fn once; callers
+// racing on the same hash block until the first completes and then share its result. fn is
+// responsible for consuming/cleaning up the caller's own staged bytes; a caller that loses
+// the race gets the winner's result back and should discard its own staging file.
+func dedupUpload(contentHash string, fn func() (*models.File, error)) (result *models.File, won bool, err error) {
+	inFlightUploadsMu.Lock()
+	once, exists := inFlightUploads[contentHash]
+	if !exists {
+		once = &uploadOnce{}
+		inFlightUploads[contentHash] = once
+	}
+	inFlightUploadsMu.Unlock()
+
+	ran := false
+	once.once.Do(func() {
+		ran = true
+		once.result, once.err = fn()
+		inFlightUploadsMu.Lock()
+		delete(inFlightUploads, contentHash)
+		inFlightUploadsMu.Unlock()
+	})
+
+	if once.err != nil {
+		logger.Warn("去重上传失败，hash=%s: %v", contentHash, once.err)
+	}
+	return once.result, ran, once.err
+}
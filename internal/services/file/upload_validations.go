@@ -3,25 +3,28 @@ package file
 /* Validation helpers split from upload_service.go (no behavior change). */
 
 import (
+	errorsStd "errors"
 	"fmt"
 	"mime/multipart"
 	"path/filepath"
 	"pixelpunk/internal/models"
+	"pixelpunk/internal/services/quota"
 	"pixelpunk/internal/services/setting"
 	"pixelpunk/pkg/common"
 	"pixelpunk/pkg/database"
 	"pixelpunk/pkg/errors"
 	"pixelpunk/pkg/logger"
 	"strings"
-	"time"
 
 	"gorm.io/gorm"
 )
 
-func validateUploadInput(ctx *UploadContext) error {
+// maxFileSizeSetting reads the admin-configured max_file_size (in MB), falling back to
+// 100MB. Shared by validateUploadInput and the resumable session completion path in
+// resumable.go so both enforce the same default-kind size limit.
+func maxFileSizeSetting() int64 {
 	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
 	maxFileSize := int64(100 * 1024 * 1024) // 默认100MB
-
 	if err == nil {
 		if maxSizeVal, ok := settingsMap.Settings["max_file_size"]; ok {
 			switch v := maxSizeVal.(type) {
@@ -32,9 +35,19 @@ func validateUploadInput(ctx *UploadContext) error {
 			case int64:
 				maxFileSize = v * 1024 * 1024
 			}
-			}
 		}
 	}
+	return maxFileSize
+}
+
+// validateFileFormat runs the size/content-sniff/media-kind checks a single file must pass,
+// shared by validateUploadInput (single-shot uploads) and validateBatchUploadFiles, so a
+// batch caller can't skip content sniffing or per-kind limits that a single-file upload
+// would enforce. It deliberately stops short of quota.Check: a batch needs to check its
+// total file count/bytes with quota.CheckN once, not one file at a time (see CheckN's
+// doc comment on why looping Check doesn't do the same thing).
+func validateFileFormat(ctx *UploadContext) error {
+	maxFileSize := maxFileSizeSetting()
 
 	if maxFileSize > 0 && ctx.File.Size > maxFileSize {
 		maxSizeMB := maxFileSize / (1024 * 1024)
@@ -43,9 +56,32 @@ func validateUploadInput(ctx *UploadContext) error {
 
 	fileExt := strings.ToLower(filepath.Ext(ctx.File.Filename))
 	ctx.FileExt = fileExt
+	ctx.MediaKind = detectMediaKind(ctx)
+
+	if err := validateContentSniff(ctx); err != nil {
+		return err
+	}
+
+	switch ctx.MediaKind {
+	case MediaKindVideo, MediaKindAudio:
+		if err := validateMediaUploadInput(ctx, maxFileSize); err != nil {
+			return err
+		}
+	default:
+		if !isValidFileType(fileExt) {
+			return errors.New(errors.CodeFileTypeNotSupported, "当前格式不被支持、请联系管理员解除限制！")
+		}
+	}
+	return nil
+}
+
+func validateUploadInput(ctx *UploadContext) error {
+	if err := validateFileFormat(ctx); err != nil {
+		return err
+	}
 
-	if !isValidFileType(fileExt) {
-		return errors.New(errors.CodeFileTypeNotSupported, "当前格式不被支持、请联系管理员解除限制！")
+	if err := quota.Check(ctx.UserID, resolveQuotaTier(ctx.UserID), ctx.File.Size); err != nil {
+		return err
 	}
 
 	if ctx.FolderID == "null" {
@@ -112,62 +148,77 @@ func validateFolder(ctx *UploadContext) error {
 	return nil
 }
 
-func validateBatchUploadFiles(files []*multipart.FileHeader) error {
+// validateBatchUploadFiles runs every file in a batch through the same content-sniff and
+// per-kind checks a single-shot upload goes through (validateFileFormat), then checks the
+// whole batch's file count and byte total against userID's quota in one quota.CheckN call,
+// so a batch upload can't bypass any check its single-file equivalent would have enforced.
+func validateBatchUploadFiles(userID uint, files []*multipart.FileHeader) error {
 	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
-	maxFileSize := int64(100 * 1024 * 1024)   // 默认20MB单文件限制
 	maxBatchSize := int64(100 * 1024 * 1024) // 默认100MB批量限制
 	if err == nil {
-		if maxSizeVal, ok := settingsMap.Settings["max_file_size"]; ok {
-			switch v := maxSizeVal.(type) {
-			case float64:
-				maxFileSize = int64(v * 1024 * 1024)
-			case int:
-				maxFileSize = int64(v) * 1024 * 1024
-			}
-			}
-		}
 		if maxBatchSizeVal, ok := settingsMap.Settings["max_batch_size"]; ok {
 			if maxBatchSizeMB, ok := maxBatchSizeVal.(float64); ok {
 				maxBatchSize = int64(maxBatchSizeMB * 1024 * 1024)
 			}
 		}
 	}
+
+	isGuestUpload := userID == 0
 	var totalSize int64
-	for _, file := range files {
-		if maxFileSize > 0 && file.Size > maxFileSize {
-			maxSizeMB := maxFileSize / (1024 * 1024)
-			return errors.New(errors.CodeFileTooLarge, fmt.Sprintf("文件%s大小超过单文件限制%dMB", file.Filename, maxSizeMB))
+	for _, fileHeader := range files {
+		ctx := &UploadContext{File: fileHeader, UserID: userID, IsGuestUpload: isGuestUpload}
+		if err := validateFileFormat(ctx); err != nil {
+			return err
 		}
-		totalSize += file.Size
+		totalSize += fileHeader.Size
 	}
 	if maxBatchSize > 0 && totalSize > maxBatchSize {
 		maxSizeMB := maxBatchSize / (1024 * 1024)
 		return errors.New(errors.CodeFileTooLarge, fmt.Sprintf("批量上传总大小不能超过%dMB", maxSizeMB))
 	}
-	return nil
+
+	return quota.CheckN(userID, resolveQuotaTier(userID), len(files), totalSize)
 }
 
+// checkDailyUploadLimit is kept for callers that only care about the daily file-count cap;
+// it now delegates to the quota service so the counters and tiers stay in one place. Prefer
+// calling quota.Check directly for new code that also needs the byte-volume dimension.
 func checkDailyUploadLimit(userID uint, uploadCount int) (bool, error) {
-	settingsMap, err := setting.GetSettingsByGroupAsMap("upload")
-	if err != nil {
-		return false, err
-	}
-	var dailyLimit int = 50 // 默认值
-	if limitVal, ok := settingsMap.Settings["daily_upload_limit"]; ok {
-		if limit, ok := limitVal.(float64); ok {
-			dailyLimit = int(limit)
+	tier := resolveQuotaTier(userID)
+	if err := quota.CheckN(userID, tier, uploadCount, 0); err != nil {
+		var quotaErr *quota.QuotaExceededError
+		if errorsStd.As(err, &quotaErr) && quotaErr.Window == quota.WindowDaily && quotaErr.Dimension == "files" {
+			return true, nil
 		}
-	}
-	if dailyLimit == -1 {
-		return false, nil
-	}
-	db := database.DB
-	var todayCount int64
-	startOfDay := time.Now().Truncate(24 * time.Hour)
-	endOfDay := startOfDay.Add(24 * time.Hour).Add(-time.Second)
-	err = db.Model(&models.File{}).Where("user_id = ? AND created_at BETWEEN ? AND ?", userID, startOfDay, endOfDay).Count(&todayCount).Error
-	if err != nil {
 		return false, err
 	}
-	return int(todayCount)+uploadCount > dailyLimit, nil
+	return false, nil
+}
+
+// ResolveQuotaTier is the exported form of resolveQuotaTier, for callers outside this
+// package (the quota status HTTP handler) that need the same role-to-tier mapping used
+// during upload validation.
+func ResolveQuotaTier(userID uint) quota.Tier {
+	return resolveQuotaTier(userID)
+}
+
+// resolveQuotaTier maps a user to their quota tier. Guests (userID == 0) and lookup
+// failures fall back to the most restrictive tier.
+func resolveQuotaTier(userID uint) quota.Tier {
+	if userID == 0 {
+		return quota.TierGuest
+	}
+	var user models.User
+	if err := database.DB.Select("role").Where("id = ?", userID).First(&user).Error; err != nil {
+		logger.Warn("查询用户角色失败，按访客配额处理: userID=%d err=%v", userID, err)
+		return quota.TierGuest
+	}
+	switch user.Role {
+	case "admin":
+		return quota.TierAdmin
+	case "vip":
+		return quota.TierVIP
+	default:
+		return quota.TierRegistered
+	}
 }
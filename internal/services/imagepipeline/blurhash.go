@@ -0,0 +1,110 @@
+package imagepipeline
+
+/* BlurHash placeholder generation. Only a single DC component (1x1) is computed - that's a
+valid BlurHash per the spec and is enough to paint an average-color placeholder while the
+real derivatives are still processing; it doesn't attempt to reproduce the AC detail a
+full-resolution component grid would capture. */
+
+import (
+	"image"
+	"math"
+
+	"pixelpunk/pkg/errors"
+)
+
+const blurHashDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// maxBlurHashSamples bounds how many pixels are actually read for the average-color
+// calculation, so a large source image doesn't make this stage the bottleneck.
+const maxBlurHashSamples = 4096
+
+// encodeBlurHash produces a 1x1-component BlurHash (6 characters) approximating img's
+// average color. Requires img to have been successfully decoded by the stdlib image
+// package; formats outside its support (webp, heic, svg, ...) skip this stage entirely.
+// The maximum-AC-component character is always present; with a single DC-only component
+// there are no AC components to quantize, so it is written as 0.
+func encodeBlurHash(img *Image) (string, error) {
+	if img.decoded == nil {
+		return "", errors.New(errors.CodeInvalidParameter, "图像未解码，无法生成BlurHash")
+	}
+
+	r, g, b := averageLinearColor(img.decoded)
+
+	hash := encode83(0, 1) // sizeFlag: 1x1 components
+	hash += encode83(0, 1) // quantized maximum AC value: none
+	hash += encode83(encodeDC(r, g, b), 4)
+	return hash, nil
+}
+
+// averageLinearColor returns the average of img's pixels in linear color space, which is
+// what a BlurHash DC component represents.
+func averageLinearColor(img image.Image) (r, g, b float64) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return 0, 0, 0
+	}
+
+	stepX, stepY := 1, 1
+	if samples := width * height; samples > maxBlurHashSamples {
+		stride := int(math.Sqrt(float64(samples) / float64(maxBlurHashSamples)))
+		if stride > 1 {
+			stepX, stepY = stride, stride
+		}
+	}
+
+	var sumR, sumG, sumB float64
+	var count int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			pr, pg, pb, _ := img.At(x, y).RGBA()
+			sumR += srgbToLinear(float64(pr>>8) / 255)
+			sumG += srgbToLinear(float64(pg>>8) / 255)
+			sumB += srgbToLinear(float64(pb>>8) / 255)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0, 0
+	}
+	return sumR / float64(count), sumG / float64(count), sumB / float64(count)
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	var out float64
+	if v <= 0.0031308 {
+		out = v*12.92*255 + 0.5
+	} else {
+		out = (1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5
+	}
+	return int(out)
+}
+
+func encodeDC(r, g, b float64) int {
+	return (linearToSRGB(r) << 16) + (linearToSRGB(g) << 8) + linearToSRGB(b)
+}
+
+func encode83(value, length int) string {
+	result := make([]byte, length)
+	for i := 0; i < length; i++ {
+		digit := (value / pow83(length-i-1)) % 83
+		result[i] = blurHashDigits[digit]
+	}
+	return string(result)
+}
+
+func pow83(exp int) int {
+	result := 1
+	for i := 0; i < exp; i++ {
+		result *= 83
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package imagepipeline
+
+/* Per-format Encoder registrations. SVG and animated GIF are registered as passthrough
+encoders since transcoding them to WebP/AVIF would lose vector scalability / animation. */
+
+import (
+	"bytes"
+	"image/jpeg"
+
+	"pixelpunk/pkg/errors"
+)
+
+// jpegEncoder re-encodes the decoded image at the given quality using the stdlib jpeg
+// package. Used as the default transcode target since AVIF/WebP encoding needs a codec
+// library outside the standard library.
+type jpegEncoder struct{}
+
+func (jpegEncoder) Format() string { return "jpeg" }
+
+func (jpegEncoder) Encode(img *Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img.decoded, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type passthroughEncoder struct{ format string }
+
+func (e passthroughEncoder) Format() string { return e.format }
+
+func (e passthroughEncoder) Encode(img *Image, quality int) ([]byte, error) {
+	return img.Data, nil
+}
+
+type unsupportedEncoder struct{ format string }
+
+func (e unsupportedEncoder) Format() string { return e.format }
+
+func (e unsupportedEncoder) Encode(img *Image, quality int) ([]byte, error) {
+	return nil, errors.New(errors.CodeNotImplemented, "暂不支持该格式的转码")
+}
+
+func init() {
+	// SVG is vector and animated GIF loses its animation under static re-encoding, so both
+	// are left as-is rather than run through the raster transcoder.
+	RegisterEncoder(passthroughEncoder{format: "svg"})
+	RegisterEncoder(passthroughEncoder{format: "gif"})
+	RegisterEncoder(jpegEncoder{})
+	// webp/avif encoding needs a codec library outside the standard library that isn't
+	// vendored into this build; registered as unsupported so a stage asking for either
+	// fails loudly (CodeNotImplemented) instead of silently falling through.
+	RegisterEncoder(unsupportedEncoder{format: "webp"})
+	RegisterEncoder(unsupportedEncoder{format: "avif"})
+}
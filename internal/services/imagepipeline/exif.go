@@ -0,0 +1,131 @@
+package imagepipeline
+
+/* Minimal JPEG/EXIF handling for exifStripStage and autoOrientStage: just enough marker
+and TIFF-tag parsing to find and drop the EXIF APP1 segment and read its orientation tag,
+without pulling in a dedicated EXIF library. Formats other than JPEG carry no EXIF segment
+in the first place, so both helpers are no-ops on anything else. */
+
+const (
+	jpegSOI  = 0xD8
+	jpegAPP1 = 0xE1
+	jpegSOS  = 0xDA
+)
+
+// exifHeader is the fixed ASCII prefix an APP1 segment carries when it holds EXIF (as
+// opposed to, say, XMP, which also uses APP1 under a different identifier).
+var exifHeader = []byte("Exif\x00\x00")
+
+// stripJPEGExif returns data with its EXIF APP1 segment (if any) removed, leaving every
+// other marker untouched. data is returned as-is if it isn't a JPEG or carries no EXIF.
+func stripJPEGExif(data []byte) []byte {
+	start, end, ok := findExifSegment(data)
+	if !ok {
+		return data
+	}
+	out := make([]byte, 0, len(data)-(end-start))
+	out = append(out, data[:start]...)
+	out = append(out, data[end:]...)
+	return out
+}
+
+// jpegOrientation reads the EXIF orientation tag (1-8) from data, defaulting to 1 (normal,
+// no transform needed) when data isn't a JPEG, carries no EXIF, or has no orientation tag.
+func jpegOrientation(data []byte) int {
+	start, end, ok := findExifSegment(data)
+	if !ok {
+		return 1
+	}
+	// start points at the FF E1 marker; skip it and the 2-byte length before the header.
+	tiff := data[start+4+len(exifHeader) : end]
+	if o := tiffOrientation(tiff); o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// findExifSegment walks JPEG markers looking for the APP1/EXIF segment, returning the
+// byte range of the whole segment (from its FF E1 marker, through the 2-byte length and the
+// "Exif\0\0" prefix, to the end of its payload) so callers can either strip or parse it. ok is false for non-JPEG data or a JPEG with no
+// EXIF segment.
+func findExifSegment(data []byte) (start, end int, ok bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegSOI {
+		return 0, 0, false
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 0, 0, false
+		}
+		marker := data[pos+1]
+		if marker == jpegSOS {
+			return 0, 0, false
+		}
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if length < 2 || pos+2+length > len(data) {
+			return 0, 0, false
+		}
+		payload := data[pos+4 : pos+2+length]
+		if marker == jpegAPP1 && len(payload) >= len(exifHeader) && string(payload[:len(exifHeader)]) == string(exifHeader) {
+			return pos, pos + 2 + length, true
+		}
+		pos += 2 + length
+	}
+	return 0, 0, false
+}
+
+// tiffOrientation parses just enough of a TIFF/EXIF IFD0 to find tag 0x0112 (Orientation),
+// returning 0 if the tag isn't present.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order tiffByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = littleEndian
+	case "MM":
+		order = bigEndian
+	default:
+		return 0
+	}
+	ifdOffset := int(order.u32(tiff[4:8]))
+	if ifdOffset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.u16(tiff[ifdOffset : ifdOffset+2]))
+	entriesStart := ifdOffset + 2
+	for i := 0; i < count; i++ {
+		entry := entriesStart + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		tag := order.u16(tiff[entry : entry+2])
+		if tag == 0x0112 {
+			return int(order.u16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 0
+}
+
+// tiffByteOrder distinguishes TIFF's two possible byte orders; EXIF allows either even though
+// JPEG itself is always big-endian.
+type tiffByteOrder uint16
+
+const (
+	littleEndian tiffByteOrder = iota
+	bigEndian
+)
+
+func (o tiffByteOrder) u16(b []byte) uint16 {
+	if o == littleEndian {
+		return uint16(b[0]) | uint16(b[1])<<8
+	}
+	return uint16(b[1]) | uint16(b[0])<<8
+}
+
+func (o tiffByteOrder) u32(b []byte) uint32 {
+	if o == littleEndian {
+		return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	}
+	return uint32(b[3]) | uint32(b[2])<<8 | uint32(b[1])<<16 | uint32(b[0])<<24
+}
@@ -0,0 +1,87 @@
+package imagepipeline
+
+import "testing"
+
+// buildJPEGWithAPP1 assembles a minimal JPEG: SOI, an APP1 segment with payload, then SOS
+// (with no actual scan data, which findExifSegment never reads past).
+func buildJPEGWithAPP1(payload []byte) []byte {
+	length := len(payload) + 2
+	data := []byte{0xFF, jpegSOI, 0xFF, jpegAPP1, byte(length >> 8), byte(length)}
+	data = append(data, payload...)
+	data = append(data, 0xFF, jpegSOS)
+	return data
+}
+
+// buildMinimalTIFF assembles a little-endian TIFF IFD0 with a single Orientation (0x0112)
+// tag set to orientation.
+func buildMinimalTIFF(orientation uint16) []byte {
+	tiff := []byte{'I', 'I', 0x2A, 0x00, 8, 0, 0, 0} // header + IFD0 offset = 8
+	entry := make([]byte, 12)
+	entry[0], entry[1] = 0x12, 0x01 // tag 0x0112, little-endian
+	entry[2], entry[3] = 3, 0       // type SHORT
+	entry[4], entry[5], entry[6], entry[7] = 1, 0, 0, 0
+	entry[8], entry[9] = byte(orientation), byte(orientation>>8)
+	ifd := []byte{1, 0} // one entry
+	ifd = append(ifd, entry...)
+	ifd = append(ifd, 0, 0, 0, 0) // next IFD offset
+	return append(tiff, ifd...)
+}
+
+func TestFindExifSegmentLocatesAPP1(t *testing.T) {
+	tiff := buildMinimalTIFF(6)
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	data := buildJPEGWithAPP1(payload)
+
+	start, end, ok := findExifSegment(data)
+	if !ok {
+		t.Fatal("expected EXIF segment to be found")
+	}
+	if data[start] != 0xFF || data[start+1] != jpegAPP1 {
+		t.Fatalf("start %d doesn't point at the APP1 marker", start)
+	}
+	if end > len(data) {
+		t.Fatalf("end %d out of range for data of length %d", end, len(data))
+	}
+}
+
+func TestFindExifSegmentNoMatchForNonJPEG(t *testing.T) {
+	if _, _, ok := findExifSegment([]byte("not a jpeg")); ok {
+		t.Fatal("expected no match for non-JPEG input")
+	}
+}
+
+func TestFindExifSegmentNoMatchWithoutAPP1(t *testing.T) {
+	data := []byte{0xFF, jpegSOI, 0xFF, jpegSOS}
+	if _, _, ok := findExifSegment(data); ok {
+		t.Fatal("expected no match for a JPEG with no APP1 segment")
+	}
+}
+
+func TestTiffOrientation(t *testing.T) {
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		tiff := buildMinimalTIFF(orientation)
+		if got := tiffOrientation(tiff); got != int(orientation) {
+			t.Errorf("tiffOrientation() = %d, want %d", got, orientation)
+		}
+	}
+}
+
+func TestTiffOrientationMissingTag(t *testing.T) {
+	tiff := []byte{'I', 'I', 0x2A, 0x00, 8, 0, 0, 0, 0, 0}
+	if got := tiffOrientation(tiff); got != 0 {
+		t.Fatalf("expected 0 for a TIFF with no IFD entries, got %d", got)
+	}
+}
+
+func TestTiffOrientationTooShort(t *testing.T) {
+	if got := tiffOrientation([]byte{'I', 'I'}); got != 0 {
+		t.Fatalf("expected 0 for truncated TIFF data, got %d", got)
+	}
+}
+
+func TestJPEGOrientationDefaultsToOneWithoutExif(t *testing.T) {
+	data := []byte{0xFF, jpegSOI, 0xFF, jpegSOS}
+	if got := jpegOrientation(data); got != 1 {
+		t.Fatalf("jpegOrientation() = %d, want 1", got)
+	}
+}
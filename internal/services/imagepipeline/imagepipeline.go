@@ -0,0 +1,330 @@
+// Package imagepipeline runs post-upload image derivatives (EXIF strip, auto-orient,
+// transcoding, thumbnails, BlurHash) as background jobs decoupled from the upload request.
+package imagepipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"sync"
+
+	"pixelpunk/internal/models"
+	"pixelpunk/internal/services/setting"
+	"pixelpunk/pkg/database"
+	"pixelpunk/pkg/errors"
+	"pixelpunk/pkg/logger"
+)
+
+// Stage is one step of the pipeline, run in order against a working copy of the image.
+// Stages mutate img in place and may set fields on result (e.g. a transcoder setting
+// result.DerivativePath, a thumbnailer appending to result.Thumbnails).
+type Stage interface {
+	Name() string
+	Run(ctx context.Context, img *Image, result *Result) error
+}
+
+// Image is the decoded working copy a stage operates on. decoded is nil when Data couldn't
+// be decoded by the stdlib image package (webp, heic, svg, ...); stages that need pixel
+// data must treat that as "nothing to do" rather than an error, since the original file is
+// already safely stored regardless of whether derivatives can be generated from it.
+type Image struct {
+	Format  string
+	Width   int
+	Height  int
+	Data    []byte
+	decoded image.Image
+}
+
+// Thumbnail is one generated derivative size.
+type Thumbnail struct {
+	Size   string // "small", "medium", "large"
+	Path   string
+	Width  int
+	Height int
+}
+
+// Result accumulates what the pipeline produced for a single source file.
+type Result struct {
+	FileID         uint
+	DerivativePath string
+	Thumbnails     []Thumbnail
+	BlurHash       string
+}
+
+// Encoder produces a derivative for one image format. Distinct encoders are registered per
+// format so SVG/HEIF/animated GIF can be handled differently from static raster formats.
+type Encoder interface {
+	Format() string
+	Encode(img *Image, quality int) ([]byte, error)
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = make(map[string]Encoder)
+)
+
+// RegisterEncoder adds an Encoder for its format, overwriting any previous registration for
+// the same format (so a plugin can swap out the default implementation).
+func RegisterEncoder(e Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[e.Format()] = e
+}
+
+func encoderFor(format string) (Encoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	e, ok := encoders[format]
+	return e, ok
+}
+
+// JobStatus is the lifecycle state of a queued pipeline job, surfaced via GetJobStatus so
+// the client can show "processing" until derivatives are ready.
+type JobStatus string
+
+const (
+	JobStatusQueued     JobStatus = "queued"
+	JobStatusProcessing JobStatus = "processing"
+	JobStatusDone       JobStatus = "done"
+	JobStatusFailed     JobStatus = "failed"
+)
+
+// pool is the bounded worker pool jobs run on, independent of the HTTP request that
+// triggered the upload.
+type pool struct {
+	jobs chan models.ImagePipelineJob
+	wg   sync.WaitGroup
+}
+
+var defaultPool *pool
+var poolOnce sync.Once
+
+func workerCount() int {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("image_pipeline")
+	if err != nil {
+		return 4
+	}
+	if v, ok := settingsMap.Settings["worker_count"]; ok {
+		if f, ok := v.(float64); ok && f > 0 {
+			return int(f)
+		}
+	}
+	return 4
+}
+
+func getPool() *pool {
+	poolOnce.Do(func() {
+		p := &pool{jobs: make(chan models.ImagePipelineJob, 256)}
+		n := workerCount()
+		for i := 0; i < n; i++ {
+			p.wg.Add(1)
+			go p.worker()
+		}
+		defaultPool = p
+	})
+	return defaultPool
+}
+
+func (p *pool) worker() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		processJob(job)
+	}
+}
+
+// dedupGuard ensures concurrent uploads of identical content only run the pipeline once,
+// mirroring the sync.Once content-hash dedup used on the upload path.
+var (
+	dedupMu sync.Mutex
+	dedup   = make(map[string]*sync.Once)
+)
+
+func dedupOnceFor(contentHash string) *sync.Once {
+	dedupMu.Lock()
+	defer dedupMu.Unlock()
+	once, ok := dedup[contentHash]
+	if !ok {
+		once = &sync.Once{}
+		dedup[contentHash] = once
+	}
+	return once
+}
+
+// Enqueue queues a pipeline job for fileID/contentHash, persisting the job row with
+// JobStatusQueued so its status survives a worker restart, and hands it to the worker pool;
+// it returns only an error, and blocks while the pool's 256-job queue is full. storagePath
+// is where the already-saved source file lives on disk - the job reads its bytes from there
+// rather than re-receiving them, since by the time a file reaches this pipeline it's
+// already been written by the upload path. Persisting the row alone doesn't re-enqueue it on
+// the next boot - jobStages (storagePath, stages) is in-memory only, so a job that was
+// "queued"/"processing" when the process died needs RecoverPendingJobs called at startup to
+// actually resume it.
+func Enqueue(fileID uint, contentHash string, storagePath string, stages []Stage) error {
+	job := models.ImagePipelineJob{
+		FileID:      fileID,
+		ContentHash: contentHash,
+		Status:      string(JobStatusQueued),
+	}
+	if err := database.DB.Create(&job).Error; err != nil {
+		return errors.Wrap(err, errors.CodeDBQueryFailed, "创建图像处理任务失败")
+	}
+	registerStages(job.ID, storagePath, stages)
+	getPool().jobs <- job
+	return nil
+}
+
+// RecoverPendingJobs re-enqueues every job row left in "queued" or "processing" status,
+// covering work that was lost when a prior process died mid-pipeline (jobStages never
+// survives a restart, since it only ever lives in memory). Callers must invoke this once
+// during process startup, before any new uploads start calling Enqueue, passing the same
+// stages a fresh upload would get (DefaultStages(), typically). A job whose file was since
+// deleted is marked JobStatusFailed instead of being resubmitted, since there's no source
+// file left to read.
+func RecoverPendingJobs(stages []Stage) error {
+	var jobs []models.ImagePipelineJob
+	if err := database.DB.Where("status IN ?", []string{string(JobStatusQueued), string(JobStatusProcessing)}).Find(&jobs).Error; err != nil {
+		return errors.Wrap(err, errors.CodeDBQueryFailed, "查询待恢复的图像处理任务失败")
+	}
+
+	for _, job := range jobs {
+		var f models.File
+		if err := database.DB.Where("id = ?", job.FileID).First(&f).Error; err != nil {
+			logger.Warn("恢复图像处理任务时找不到源文件，标记为失败: job=%d fileID=%d err=%v", job.ID, job.FileID, err)
+			setJobStatus(job.ID, JobStatusFailed)
+			continue
+		}
+		registerStages(job.ID, f.StoragePath, stages)
+		getPool().jobs <- job
+	}
+	return nil
+}
+
+type jobContext struct {
+	storagePath string
+	stages      []Stage
+}
+
+var (
+	jobStagesMu sync.Mutex
+	jobStages   = make(map[uint]jobContext)
+)
+
+func registerStages(jobID uint, storagePath string, stages []Stage) {
+	jobStagesMu.Lock()
+	defer jobStagesMu.Unlock()
+	jobStages[jobID] = jobContext{storagePath: storagePath, stages: stages}
+}
+
+func contextFor(jobID uint) jobContext {
+	jobStagesMu.Lock()
+	defer jobStagesMu.Unlock()
+	return jobStages[jobID]
+}
+
+func releaseStages(jobID uint) {
+	jobStagesMu.Lock()
+	defer jobStagesMu.Unlock()
+	delete(jobStages, jobID)
+}
+
+func processJob(job models.ImagePipelineJob) {
+	once := dedupOnceFor(job.ContentHash)
+	once.Do(func() {
+		runJob(job)
+		dedupMu.Lock()
+		delete(dedup, job.ContentHash)
+		dedupMu.Unlock()
+	})
+}
+
+func runJob(job models.ImagePipelineJob) {
+	defer releaseStages(job.ID)
+	setJobStatus(job.ID, JobStatusProcessing)
+
+	jc := contextFor(job.ID)
+	data, err := os.ReadFile(jc.storagePath)
+	if err != nil {
+		logger.Warn("读取图像处理源文件失败: job=%d path=%s err=%v", job.ID, jc.storagePath, err)
+		setJobStatus(job.ID, JobStatusFailed)
+		return
+	}
+
+	img := &Image{Data: data}
+	if decoded, format, decodeErr := image.Decode(bytes.NewReader(data)); decodeErr == nil {
+		img.decoded = decoded
+		img.Format = format
+		bounds := decoded.Bounds()
+		img.Width = bounds.Dx()
+		img.Height = bounds.Dy()
+	} else {
+		// Formats the stdlib image package doesn't recognize (webp, heic, svg, ...) simply
+		// get no pixel-dependent derivatives; the stages below treat a nil img.decoded as
+		// "nothing to do" rather than failing the job outright.
+		logger.Warn("图像解码失败，跳过依赖像素数据的处理阶段: job=%d err=%v", job.ID, decodeErr)
+	}
+
+	result := &Result{FileID: job.FileID}
+
+	ctx := context.Background()
+	for _, stage := range jc.stages {
+		if err := stage.Run(ctx, img, result); err != nil {
+			logger.Warn("图像处理阶段失败: job=%d stage=%s err=%v", job.ID, stage.Name(), err)
+			setJobStatus(job.ID, JobStatusFailed)
+			return
+		}
+	}
+
+	thumbnailsJSON, err := json.Marshal(result.Thumbnails)
+	if err != nil {
+		logger.Warn("序列化缩略图信息失败: job=%d err=%v", job.ID, err)
+		thumbnailsJSON = []byte("[]")
+	}
+	if err := database.DB.Model(&models.File{}).Where("id = ?", job.FileID).Updates(map[string]any{
+		"blur_hash":       result.BlurHash,
+		"derivative_path": result.DerivativePath,
+		"thumbnails":      string(thumbnailsJSON),
+	}).Error; err != nil {
+		logger.Warn("保存图像处理结果失败: job=%d err=%v", job.ID, err)
+	}
+	setJobStatus(job.ID, JobStatusDone)
+}
+
+func setJobStatus(jobID uint, status JobStatus) {
+	if err := database.DB.Model(&models.ImagePipelineJob{}).Where("id = ?", jobID).Update("status", string(status)).Error; err != nil {
+		logger.Warn("更新图像处理任务状态失败: job=%d status=%s err=%v", jobID, status, err)
+	}
+}
+
+// GetJobStatus returns the current status of a previously enqueued job.
+func GetJobStatus(jobID uint) (JobStatus, error) {
+	var job models.ImagePipelineJob
+	if err := database.DB.Where("id = ?", jobID).First(&job).Error; err != nil {
+		return "", errors.Wrap(err, errors.CodeDBQueryFailed, fmt.Sprintf("查询图像处理任务失败: job=%d", jobID))
+	}
+	return JobStatus(job.Status), nil
+}
+
+// GetJobStatusForUser is GetJobStatus scoped to the job's owning file, so one user can't
+// poll another user's job ID for status. A mismatch is reported identically to a missing
+// job rather than a dedicated "forbidden" error, mirroring internal/services/file.loadSession
+// - it shouldn't confirm the job ID exists to a caller who doesn't own it.
+func GetJobStatusForUser(jobID uint, userID uint) (JobStatus, error) {
+	var job models.ImagePipelineJob
+	if err := database.DB.Where("id = ?", jobID).First(&job).Error; err != nil {
+		return "", errors.New(errors.CodeFileNotFound, "任务不存在")
+	}
+	var f models.File
+	if err := database.DB.Where("id = ?", job.FileID).First(&f).Error; err != nil {
+		return "", errors.New(errors.CodeFileNotFound, "任务不存在")
+	}
+	if f.UserID != userID {
+		return "", errors.New(errors.CodeFileNotFound, "任务不存在")
+	}
+	return JobStatus(job.Status), nil
+}
@@ -0,0 +1,107 @@
+package imagepipeline
+
+/* Pixel-level transforms backing autoOrientStage. Like resizeToLongestEdge in stages.go,
+these are plain nearest-neighbor pixel copies rather than anything SIMD/library-accelerated
+- correct output matters more than throughput for a once-per-upload background job. */
+
+import "image"
+
+// applyOrientation returns src transformed according to an EXIF orientation value (1-8, see
+// jpegOrientation), or src unchanged for 1 or any value outside that range.
+func applyOrientation(src image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return flipH(src)
+	case 3:
+		return rotate180(src)
+	case 4:
+		return flipV(src)
+	case 5:
+		return transpose(src)
+	case 6:
+		return rotate90CW(src)
+	case 7:
+		return rotate180(transpose(src))
+	case 8:
+		return rotate270CW(src)
+	default:
+		return src
+	}
+}
+
+func flipH(src image.Image) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, src.At(b.Min.X+w-1-x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func flipV(src image.Image) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, src.At(b.Min.X+x, b.Min.Y+h-1-y))
+		}
+	}
+	return dst
+}
+
+func rotate180(src image.Image) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, src.At(b.Min.X+w-1-x, b.Min.Y+h-1-y))
+		}
+	}
+	return dst
+}
+
+// transpose mirrors src about its top-left/bottom-right diagonal (EXIF orientation 5),
+// swapping width and height.
+func transpose(src image.Image) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+	for i := 0; i < w; i++ {
+		for j := 0; j < h; j++ {
+			dst.Set(j, i, src.At(b.Min.X+i, b.Min.Y+j))
+		}
+	}
+	return dst
+}
+
+// rotate90CW rotates src 90 degrees clockwise (EXIF orientation 6), swapping width/height.
+func rotate90CW(src image.Image) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+	for i := 0; i < w; i++ {
+		for j := 0; j < h; j++ {
+			dst.Set(j, i, src.At(b.Min.X+i, b.Min.Y+h-1-j))
+		}
+	}
+	return dst
+}
+
+// rotate270CW rotates src 270 degrees clockwise, i.e. 90 degrees counter-clockwise (EXIF
+// orientation 8), swapping width/height.
+func rotate270CW(src image.Image) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+	for i := 0; i < w; i++ {
+		for j := 0; j < h; j++ {
+			dst.Set(j, i, src.At(b.Min.X+w-1-i, b.Min.Y+j))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,56 @@
+package imagepipeline
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// tagged2x1 builds a 2x1 RGBA image with distinguishable pixels: red at (0,0), blue at (1,0).
+func tagged2x1() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	img.Set(1, 0, color.RGBA{B: 255, A: 255})
+	return img
+}
+
+func at(img image.Image, x, y int) color.RGBA {
+	r, g, b, a := img.At(x, y).RGBA()
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+}
+
+func TestApplyOrientationIdentityForOneAndOutOfRange(t *testing.T) {
+	src := tagged2x1()
+	for _, orientation := range []int{1, 0, 9, -1} {
+		out := applyOrientation(src, orientation)
+		if out != image.Image(src) {
+			t.Fatalf("orientation %d: expected src returned unchanged", orientation)
+		}
+	}
+}
+
+func TestApplyOrientationFlipH(t *testing.T) {
+	src := tagged2x1()
+	out := applyOrientation(src, 2)
+	if at(out, 0, 0).B != 255 || at(out, 1, 0).R != 255 {
+		t.Fatalf("orientation 2 (flipH): pixels not swapped as expected")
+	}
+}
+
+func TestApplyOrientationRotate90CWSwapsDimensions(t *testing.T) {
+	src := tagged2x1() // 2x1
+	out := applyOrientation(src, 6)
+	b := out.Bounds()
+	if b.Dx() != 1 || b.Dy() != 2 {
+		t.Fatalf("orientation 6 (rotate90CW): expected 1x2 output, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestApplyOrientationRotate270CWSwapsDimensions(t *testing.T) {
+	src := tagged2x1() // 2x1
+	out := applyOrientation(src, 8)
+	b := out.Bounds()
+	if b.Dx() != 1 || b.Dy() != 2 {
+		t.Fatalf("orientation 8 (rotate270CW): expected 1x2 output, got %dx%d", b.Dx(), b.Dy())
+	}
+}
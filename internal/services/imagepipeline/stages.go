@@ -0,0 +1,232 @@
+package imagepipeline
+
+/* Concrete pipeline stages: EXIF strip, auto-orient, transcoding and thumbnail generation.
+Each stage is intentionally small and independent so DefaultStages can be reordered or
+trimmed per upload without touching the worker pool itself. */
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+
+	"pixelpunk/internal/services/setting"
+	"pixelpunk/pkg/logger"
+)
+
+// derivativeStorageRoot is where transcoded derivatives and thumbnails are written,
+// mirroring the content-addressed layout used for the original uploads in
+// internal/services/file/upload_service.go.
+const derivativeStorageRoot = "storage"
+
+// thumbnailSizes maps a named size to its target longest-edge dimension, overridable via
+// the image_pipeline setting group.
+func thumbnailSizes() map[string]int {
+	defaults := map[string]int{"small": 160, "medium": 480, "large": 1024}
+	settingsMap, err := setting.GetSettingsByGroupAsMap("image_pipeline")
+	if err != nil {
+		return defaults
+	}
+	for name := range defaults {
+		if v, ok := settingsMap.Settings[name+"_thumbnail_size"]; ok {
+			if f, ok := v.(float64); ok && f > 0 {
+				defaults[name] = int(f)
+			}
+		}
+	}
+	return defaults
+}
+
+// exifStripStage removes the EXIF APP1 segment from img.Data so location/device metadata
+// never makes it into a passthrough or transcoded derivative. It runs after autoOrientStage,
+// which still needs the orientation tag this stage deletes. Only JPEG carries an EXIF
+// segment in the formats this pipeline handles, so it's a no-op on everything else.
+type exifStripStage struct{}
+
+func (exifStripStage) Name() string { return "exif_strip" }
+
+func (exifStripStage) Run(ctx context.Context, img *Image, result *Result) error {
+	img.Data = stripJPEGExif(img.Data)
+	return nil
+}
+
+// autoOrientStage rotates/flips the decoded pixel data according to the EXIF orientation
+// tag so downstream stages (and the browser, once orientation metadata is gone) see it
+// upright. Only JPEG carries an orientation tag in the formats this pipeline handles, and
+// formats the stdlib couldn't decode have no decoded pixels to rotate in the first place.
+type autoOrientStage struct{}
+
+func (autoOrientStage) Name() string { return "auto_orient" }
+
+func (autoOrientStage) Run(ctx context.Context, img *Image, result *Result) error {
+	if img.decoded == nil {
+		return nil
+	}
+	orientation := jpegOrientation(img.Data)
+	if orientation == 1 {
+		return nil
+	}
+	img.decoded = applyOrientation(img.decoded, orientation)
+	bounds := img.decoded.Bounds()
+	img.Width, img.Height = bounds.Dx(), bounds.Dy()
+	return nil
+}
+
+// transcodeStage re-encodes large JPEG/PNG sources to targetFormat, using the registered
+// Encoder for that format; other source formats are left as-is. "webp" and "avif" are
+// registered as unsupportedEncoder (see encoders.go), so a stage targeting either fails the
+// job instead of producing an AVIF/WebP derivative; only the "jpeg" target writes one.
+type transcodeStage struct {
+	targetFormat string
+	minBytes     int
+	quality      int
+}
+
+func (s transcodeStage) Name() string { return "transcode_" + s.targetFormat }
+
+func (s transcodeStage) Run(ctx context.Context, img *Image, result *Result) error {
+	if len(img.Data) < s.minBytes {
+		return nil
+	}
+	if img.decoded == nil {
+		// Source format wasn't decodable by the stdlib image package (webp, heic, svg, ...) -
+		// nothing to re-encode from, so skip rather than fail the job.
+		logger.Warn("源图像未解码，跳过转码: file=%d format=%s", result.FileID, s.targetFormat)
+		return nil
+	}
+	if img.Format != "jpeg" && img.Format != "png" {
+		return nil
+	}
+	encoder, ok := encoderFor(s.targetFormat)
+	if !ok {
+		return nil
+	}
+	data, err := encoder.Encode(img, s.quality)
+	if err != nil {
+		return fmt.Errorf("transcode to %s: %w", s.targetFormat, err)
+	}
+
+	path := filepath.Join(derivativeStorageRoot, "derivatives", fmt.Sprintf("%d.%s", result.FileID, s.targetFormat))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("transcode to %s: %w", s.targetFormat, err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("transcode to %s: %w", s.targetFormat, err)
+	}
+	result.DerivativePath = path
+	return nil
+}
+
+// thumbnailStage generates one Thumbnail per configured named size.
+type thumbnailStage struct{}
+
+func (thumbnailStage) Name() string { return "thumbnails" }
+
+func (thumbnailStage) Run(ctx context.Context, img *Image, result *Result) error {
+	if img.decoded == nil {
+		logger.Warn("源图像未解码，跳过缩略图生成: file=%d", result.FileID)
+		return nil
+	}
+
+	for name, edge := range thumbnailSizes() {
+		thumb := resizeToLongestEdge(img.decoded, edge)
+		path := filepath.Join(derivativeStorageRoot, "thumbnails", fmt.Sprintf("%d", result.FileID), name+".jpg")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("thumbnail %s: %w", name, err)
+		}
+		out, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("thumbnail %s: %w", name, err)
+		}
+		encErr := jpeg.Encode(out, thumb, &jpeg.Options{Quality: 85})
+		out.Close()
+		if encErr != nil {
+			return fmt.Errorf("thumbnail %s: %w", name, encErr)
+		}
+
+		bounds := thumb.Bounds()
+		result.Thumbnails = append(result.Thumbnails, Thumbnail{
+			Size:   name,
+			Path:   path,
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		})
+	}
+	return nil
+}
+
+// resizeToLongestEdge scales src down so its longest edge is at most maxEdge, using
+// nearest-neighbor sampling - good enough for a placeholder-quality thumbnail without
+// pulling in an external resize library. Images already within maxEdge are returned as-is.
+func resizeToLongestEdge(src image.Image, maxEdge int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= 0 || srcH <= 0 || maxEdge <= 0 {
+		return src
+	}
+	longest := srcW
+	if srcH > longest {
+		longest = srcH
+	}
+	if longest <= maxEdge {
+		return src
+	}
+
+	scale := float64(maxEdge) / float64(longest)
+	dstW := maxInt(1, int(float64(srcW)*scale))
+	dstH := maxInt(1, int(float64(srcH)*scale))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		srcY := bounds.Min.Y + y*srcH/dstH
+		for x := 0; x < dstW; x++ {
+			srcX := bounds.Min.X + x*srcW/dstW
+			dst.Set(x, y, src.At(srcX, srcY))
+		}
+	}
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// blurHashStage computes a compact placeholder hash the client can render while the full
+// derivative is still processing.
+type blurHashStage struct{}
+
+func (blurHashStage) Name() string { return "blurhash" }
+
+func (blurHashStage) Run(ctx context.Context, img *Image, result *Result) error {
+	if img.decoded == nil {
+		logger.Warn("源图像未解码，跳过BlurHash生成: file=%d", result.FileID)
+		return nil
+	}
+	hash, err := encodeBlurHash(img)
+	if err != nil {
+		return err
+	}
+	result.BlurHash = hash
+	return nil
+}
+
+// DefaultStages is the stage order used for a standard image upload: correct orientation
+// (while the EXIF tag it depends on is still there), strip metadata, recompress large
+// JPEG/PNG sources as JPEG, then derive thumbnails and a BlurHash. AVIF/WebP transcoding isn't wired up yet -
+// encoding either needs a codec library outside the standard library, so until one is
+// vendored in, "webp"/"avif" stay registered as unsupportedEncoder (see encoders.go) and
+// the only transcode target is a recompressed JPEG.
+func DefaultStages() []Stage {
+	return []Stage{
+		autoOrientStage{},
+		exifStripStage{},
+		transcodeStage{targetFormat: "jpeg", minBytes: 200 * 1024, quality: 80},
+		thumbnailStage{},
+		blurHashStage{},
+	}
+}
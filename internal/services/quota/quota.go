@@ -0,0 +1,287 @@
+// Package quota tracks per-user upload usage against role-tiered limits. Counters are kept
+// as Redis sliding windows for speed, with a DB COUNT(*) fallback when Redis is unavailable.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"pixelpunk/internal/models"
+	"pixelpunk/internal/services/setting"
+	"pixelpunk/pkg/database"
+	"pixelpunk/pkg/errors"
+	"pixelpunk/pkg/logger"
+	"pixelpunk/pkg/redis"
+)
+
+// Tier is a user role that quotas are configured per.
+type Tier string
+
+const (
+	TierGuest      Tier = "guest"
+	TierRegistered Tier = "registered"
+	TierVIP        Tier = "vip"
+	TierAdmin      Tier = "admin"
+)
+
+// Window is a rolling accounting period a limit can be set on.
+type Window string
+
+const (
+	WindowDaily   Window = "daily"
+	WindowWeekly  Window = "weekly"
+	WindowMonthly Window = "monthly"
+)
+
+func (w Window) duration() time.Duration {
+	switch w {
+	case WindowWeekly:
+		return 7 * 24 * time.Hour
+	case WindowMonthly:
+		return 30 * 24 * time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// Limits is the configured cap for a single tier: how many files and how many bytes may be
+// uploaded per window. A zero value means "no limit" is not assumed - -1 means unlimited.
+type Limits struct {
+	MaxFiles int64
+	MaxBytes int64
+}
+
+// QuotaExceededError reports which dimension (file count or byte volume, for which window)
+// was exceeded, plus when the window resets so the caller can surface a retry time.
+type QuotaExceededError struct {
+	Tier      Tier
+	Window    Window
+	Dimension string // "files" or "bytes"
+	Limit     int64
+	Used      int64
+	ResetAt   time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded: tier=%s window=%s dimension=%s used=%d limit=%d", e.Tier, e.Window, e.Dimension, e.Used, e.Limit)
+}
+
+// Status is the usage snapshot returned by GetQuotaStatus, one entry per configured window,
+// so the frontend can render a usage bar per dimension.
+type Status struct {
+	Tier    Tier
+	Window  Window
+	Files   int64
+	Bytes   int64
+	Limits  Limits
+	ResetAt time.Time
+}
+
+func defaultLimits(tier Tier, window Window) Limits {
+	switch tier {
+	case TierAdmin:
+		return Limits{MaxFiles: -1, MaxBytes: -1}
+	case TierVIP:
+		switch window {
+		case WindowDaily:
+			return Limits{MaxFiles: 500, MaxBytes: 20 * 1024 * 1024 * 1024}
+		case WindowWeekly:
+			return Limits{MaxFiles: 2000, MaxBytes: 100 * 1024 * 1024 * 1024}
+		default:
+			return Limits{MaxFiles: 6000, MaxBytes: 300 * 1024 * 1024 * 1024}
+		}
+	case TierRegistered:
+		switch window {
+		case WindowDaily:
+			return Limits{MaxFiles: 50, MaxBytes: 2 * 1024 * 1024 * 1024}
+		case WindowWeekly:
+			return Limits{MaxFiles: 200, MaxBytes: 10 * 1024 * 1024 * 1024}
+		default:
+			return Limits{MaxFiles: 500, MaxBytes: 30 * 1024 * 1024 * 1024}
+		}
+	default: // TierGuest
+		switch window {
+		case WindowDaily:
+			return Limits{MaxFiles: 10, MaxBytes: 200 * 1024 * 1024}
+		case WindowWeekly:
+			return Limits{MaxFiles: 30, MaxBytes: 500 * 1024 * 1024}
+		default:
+			return Limits{MaxFiles: 60, MaxBytes: 1024 * 1024 * 1024}
+		}
+	}
+}
+
+// limitsFor resolves the configured limit for tier+window, falling back to defaultLimits
+// when the setting group doesn't override it - same pattern as setting.GetSettingsByGroupAsMap
+// used elsewhere in the upload path.
+func limitsFor(tier Tier, window Window) Limits {
+	settingsMap, err := setting.GetSettingsByGroupAsMap("quota")
+	if err != nil {
+		return defaultLimits(tier, window)
+	}
+	limits := defaultLimits(tier, window)
+	filesKey := fmt.Sprintf("%s_%s_max_files", tier, window)
+	bytesKey := fmt.Sprintf("%s_%s_max_bytes", tier, window)
+	if v, ok := settingsMap.Settings[filesKey]; ok {
+		if f, ok := v.(float64); ok {
+			limits.MaxFiles = int64(f)
+		}
+	}
+	if v, ok := settingsMap.Settings[bytesKey]; ok {
+		if f, ok := v.(float64); ok {
+			limits.MaxBytes = int64(f)
+		}
+	}
+	return limits
+}
+
+func bucketKey(userID uint, window Window, dimension string) string {
+	return fmt.Sprintf("quota:%d:%s:%s", userID, window, dimension)
+}
+
+// Check verifies that uploading one more file of size bytes would stay within userID's tier
+// limits across all three windows, returning a *QuotaExceededError for the first dimension
+// that would be exceeded.
+func Check(userID uint, tier Tier, size int64) error {
+	return CheckN(userID, tier, 1, size)
+}
+
+// CheckN is the batch form of Check: it verifies that uploading fileCount more files
+// totalling size bytes would stay within userID's tier limits. Callers validating a whole
+// batch up front (validateBatchUploadFiles, checkDailyUploadLimit) must use this instead of
+// calling Check in a loop - Check only ever reads the current snapshot, so looping it
+// checks "current+1" against the limit on every iteration instead of "current+N".
+func CheckN(userID uint, tier Tier, fileCount int, size int64) error {
+	for _, window := range []Window{WindowDaily, WindowWeekly, WindowMonthly} {
+		limits := limitsFor(tier, window)
+		if limits.MaxFiles == -1 && limits.MaxBytes == -1 {
+			continue
+		}
+		files, bytesUsed, resetAt, err := usage(userID, window)
+		if err != nil {
+			logger.Warn("读取配额计数失败，回退为放行: userID=%d window=%s err=%v", userID, window, err)
+			continue
+		}
+		if limits.MaxFiles != -1 && files+int64(fileCount) > limits.MaxFiles {
+			return &QuotaExceededError{Tier: tier, Window: window, Dimension: "files", Limit: limits.MaxFiles, Used: files, ResetAt: resetAt}
+		}
+		if limits.MaxBytes != -1 && bytesUsed+size > limits.MaxBytes {
+			return &QuotaExceededError{Tier: tier, Window: window, Dimension: "bytes", Limit: limits.MaxBytes, Used: bytesUsed, ResetAt: resetAt}
+		}
+	}
+	return nil
+}
+
+// Record accounts one more upload of size bytes against userID's counters for every window.
+func Record(userID uint, size int64) error {
+	for _, window := range []Window{WindowDaily, WindowWeekly, WindowMonthly} {
+		if err := incr(userID, window, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usage reads the current file/byte counters for userID+window, preferring Redis and
+// falling back to a DB COUNT(*)/SUM(size) query when Redis is unreachable.
+func usage(userID uint, window Window) (files int64, bytesUsed int64, resetAt time.Time, err error) {
+	if redis.Client == nil {
+		return usageFromDB(userID, window)
+	}
+
+	ctx := context.Background()
+	filesKey := bucketKey(userID, window, "files")
+	filesVal, err1 := redis.Client.Get(ctx, filesKey).Int64()
+	bytesVal, err2 := redis.Client.Get(ctx, bucketKey(userID, window, "bytes")).Int64()
+	if err1 != nil && err1 != redis.Nil {
+		return usageFromDB(userID, window)
+	}
+	if err2 != nil && err2 != redis.Nil {
+		return usageFromDB(userID, window)
+	}
+	return filesVal, bytesVal, resetAtFromTTL(ctx, filesKey, window), nil
+}
+
+// resetAtFromTTL reports when key's window actually expires, reading the real remaining
+// TTL instead of assuming a full window is still left - incr only (re)sets the TTL the
+// first time a key is created, so a user who has uploaded repeatedly within the window
+// would otherwise always see the reset time pushed back to "now + a full window".
+func resetAtFromTTL(ctx context.Context, key string, window Window) time.Time {
+	ttl, err := redis.Client.TTL(ctx, key).Result()
+	if err != nil || ttl <= 0 {
+		return time.Now().Add(window.duration())
+	}
+	return time.Now().Add(ttl)
+}
+
+func usageFromDB(userID uint, window Window) (int64, int64, time.Time, error) {
+	since := time.Now().Add(-window.duration())
+	resetAt := time.Now().Add(window.duration())
+	var files int64
+	var bytesUsed int64
+	row := database.DB.Model(&models.File{}).Where("user_id = ? AND created_at >= ?", userID, since)
+	if err := row.Count(&files).Error; err != nil {
+		return 0, 0, resetAt, errors.Wrap(err, errors.CodeDBQueryFailed, "查询配额使用量失败")
+	}
+	if err := database.DB.Model(&models.File{}).Where("user_id = ? AND created_at >= ?", userID, since).Select("COALESCE(SUM(size), 0)").Scan(&bytesUsed).Error; err != nil {
+		return 0, 0, resetAt, errors.Wrap(err, errors.CodeDBQueryFailed, "查询配额使用量失败")
+	}
+	return files, bytesUsed, resetAt, nil
+}
+
+// incr bumps the Redis counters for userID+window by one file and size bytes. The window
+// TTL is only (re)set the first time a key is created - resetting it on every increment
+// would mean an active uploader's window never actually rolls over, and usage's reported
+// resetAt would always read "a full window from now" instead of the real remaining time.
+func incr(userID uint, window Window, size int64) error {
+	if redis.Client == nil {
+		// No DB-side counter to increment: usageFromDB recomputes from the files table
+		// directly, so there's nothing to persist here.
+		return nil
+	}
+	ctx := context.Background()
+	filesKey := bucketKey(userID, window, "files")
+	bytesKey := bucketKey(userID, window, "bytes")
+
+	pipe := redis.Client.Pipeline()
+	filesIncr := pipe.IncrBy(ctx, filesKey, 1)
+	bytesIncr := pipe.IncrBy(ctx, bytesKey, size)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, errors.CodeCacheWriteFailed, "写入配额计数失败")
+	}
+
+	// filesIncr.Val() == 1 unambiguously means "this key was just created": every upload
+	// increments it by exactly 1, so the bucket can only read 1 right after creation. bytesIncr
+	// can't use the same trick - IncrBy's return value equals size on a fresh key, but it
+	// would also equal size again on a key that happened to still be sitting at 0 from an
+	// earlier zero-byte upload, which would wrongly re-arm the TTL. Key the bytes TTL off of
+	// whether the files key (known first-write signal for the same window) was also just
+	// created instead.
+	if filesIncr.Val() == 1 {
+		redis.Client.Expire(ctx, filesKey, window.duration())
+		redis.Client.Expire(ctx, bytesKey, window.duration())
+	}
+	return nil
+}
+
+// GetQuotaStatus returns the usage snapshot for every window so the frontend can render a
+// per-dimension usage bar.
+func GetQuotaStatus(userID uint, tier Tier) ([]Status, error) {
+	statuses := make([]Status, 0, 3)
+	for _, window := range []Window{WindowDaily, WindowWeekly, WindowMonthly} {
+		files, bytesUsed, resetAt, err := usage(userID, window)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, Status{
+			Tier:    tier,
+			Window:  window,
+			Files:   files,
+			Bytes:   bytesUsed,
+			Limits:  limitsFor(tier, window),
+			ResetAt: resetAt,
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,53 @@
+package quota
+
+import "testing"
+
+func TestWindowDuration(t *testing.T) {
+	if WindowDaily.duration() >= WindowWeekly.duration() {
+		t.Fatalf("expected daily window shorter than weekly")
+	}
+	if WindowWeekly.duration() >= WindowMonthly.duration() {
+		t.Fatalf("expected weekly window shorter than monthly")
+	}
+}
+
+func TestDefaultLimitsAdminUnlimited(t *testing.T) {
+	for _, window := range []Window{WindowDaily, WindowWeekly, WindowMonthly} {
+		limits := defaultLimits(TierAdmin, window)
+		if limits.MaxFiles != -1 || limits.MaxBytes != -1 {
+			t.Fatalf("expected admin tier to be unlimited for %s, got %+v", window, limits)
+		}
+	}
+}
+
+func TestDefaultLimitsIncreaseWithWindow(t *testing.T) {
+	for _, tier := range []Tier{TierGuest, TierRegistered, TierVIP} {
+		daily := defaultLimits(tier, WindowDaily)
+		weekly := defaultLimits(tier, WindowWeekly)
+		monthly := defaultLimits(tier, WindowMonthly)
+		if weekly.MaxFiles <= daily.MaxFiles || monthly.MaxFiles <= weekly.MaxFiles {
+			t.Fatalf("expected MaxFiles to increase daily < weekly < monthly for tier %s, got %d/%d/%d",
+				tier, daily.MaxFiles, weekly.MaxFiles, monthly.MaxFiles)
+		}
+	}
+}
+
+func TestDefaultLimitsTierOrdering(t *testing.T) {
+	for _, window := range []Window{WindowDaily, WindowWeekly, WindowMonthly} {
+		guest := defaultLimits(TierGuest, window)
+		registered := defaultLimits(TierRegistered, window)
+		vip := defaultLimits(TierVIP, window)
+		if registered.MaxFiles <= guest.MaxFiles || vip.MaxFiles <= registered.MaxFiles {
+			t.Fatalf("expected MaxFiles to increase guest < registered < vip for %s, got %d/%d/%d",
+				window, guest.MaxFiles, registered.MaxFiles, vip.MaxFiles)
+		}
+	}
+}
+
+func TestQuotaExceededErrorMessage(t *testing.T) {
+	err := &QuotaExceededError{Tier: TierGuest, Window: WindowDaily, Dimension: "files", Limit: 10, Used: 10}
+	msg := err.Error()
+	if msg == "" {
+		t.Fatal("expected non-empty error message")
+	}
+}